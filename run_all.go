@@ -1,158 +1,6837 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
 )
 
 type ScriptResult struct {
-	Name     string
-	Success  bool
-	Duration time.Duration
-	Error    error
-	Output   string
+	Name     string        `json:"name"`
+	Success  bool          `json:"success"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    error         `json:"-"`
+	// Output interleaves both streams as they arrived; StdoutText and
+	// StderrText hold each stream alone. All three keep only the tail and
+	// are dropped for successful scripts.
+	Output     string             `json:"output,omitempty"`
+	StdoutText string             `json:"stdout,omitempty"`
+	StderrText string             `json:"stderr,omitempty"`
+	Stats      map[string]float64 `json:"stats,omitempty"`
+	Symbols    int                `json:"symbols"`
+	// Summary is the final "ExceptionType: message" line of a Python
+	// traceback found in the output of a failed script.
+	Summary string `json:"summary,omitempty"`
+	LogFile string `json:"log_file,omitempty"`
+	// MsPerSymbol is Duration divided by Symbols, a sanity metric for
+	// exchanges that are slow relative to their output size.
+	MsPerSymbol float64 `json:"ms_per_symbol,omitempty"`
+	// APIDuration is the time the script reports spending on exchange
+	// API calls via "::stat api_ms=N", as opposed to startup and parsing.
+	APIDuration time.Duration `json:"api_duration_ns,omitempty"`
+	// Checksum is the SHA-256 of the exchange's output directory; Change
+	// compares it with the previous run in the history ("changed",
+	// "unchanged", or empty when there is nothing to compare against).
+	Checksum string `json:"checksum,omitempty"`
+	Change   string `json:"change,omitempty"`
+	// Format is the exchange's effective symbol format; PreviousFormat is
+	// set when it differs from the previous run in the history.
+	Format         string `json:"format,omitempty"`
+	PreviousFormat string `json:"previous_format,omitempty"`
+	// FormatMismatch describes output that contradicts Format, e.g. a
+	// dashed symbol under remove_dash; a failure under -strict-format.
+	FormatMismatch string `json:"format_mismatch,omitempty"`
+	// Skipped marks a run whose exit code the exchange maps to "skip": it
+	// neither succeeded nor failed, and Success is set so it fails nothing.
+	// It also marks an exchange not run because a dependency failed, with
+	// Success false and Error naming the dependency.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Partial marks a run that timed out after producing some output,
+	// under -keep-partial. Success stays false, but it doesn't fail the
+	// run.
+	Partial bool `json:"partial,omitempty"`
+
+	// Attempts records every run of the script, retries included; the
+	// fields above describe the last one. Retries is the number of retries
+	// that were allowed, from -retries or the exchange's override.
+	Attempts []Attempt `json:"attempts,omitempty"`
+	Retries  int       `json:"retries"`
+
+	// permanent marks a failure whose exit code maps to "failure", which
+	// is not retried.
+	permanent bool
+	exitCode  int
 }
 
-func runPythonScript(scriptPath string, current int, total int) ScriptResult {
-	start := time.Now()
-	scriptName := filepath.Base(scriptPath)
-	scriptName = strings.TrimSuffix(scriptName, ".py")
+// Attempt is one run of a script within runWithRetries.
+type Attempt struct {
+	Number   int           `json:"number"` // 1-based
+	Started  time.Time     `json:"started,omitzero"`
+	Duration time.Duration `json:"duration_ns"`
+	ExitCode int           `json:"exit_code"` // -1 when killed by a signal or never started
+	Error    string        `json:"error,omitempty"`
+}
 
-	progress := float64(current) / float64(total) * 100
-	fmt.Printf("🔄 [%d/%d - %.1f%%] Starting %s...\n", current, total, progress, scriptName)
-	fmt.Printf("📋 Output from %s:\n", scriptName)
-	fmt.Println(strings.Repeat("-", 40))
+// exitCodeOf returns the exit code behind a Run error: 0 for nil, -1 when
+// the script was killed by a signal or could not be started.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
 
-	cmd := exec.Command("python3", scriptPath)
-	cmd.Dir = filepath.Dir(scriptPath)
-	
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func (r *ScriptResult) UnmarshalJSON(data []byte) error {
+	type plain ScriptResult
+	aux := struct {
+		*plain
+		Error string `json:"error,omitempty"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Error != "" {
+		r.Error = errors.New(aux.Error)
+	}
+	return nil
+}
 
-	err := cmd.Run()
-	duration := time.Since(start)
+func (r ScriptResult) MarshalJSON() ([]byte, error) {
+	type plain ScriptResult
+	var errText string
+	if r.Error != nil {
+		errText = r.Error.Error()
+	}
+	return json.Marshal(struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain(r), errText})
+}
 
-	result := ScriptResult{
-		Name:     scriptName,
-		Success:  err == nil,
-		Duration: duration,
-		Error:    err,
-		Output:   "",
+// durationPrecision rounds the durations in human output
+// (-duration-precision); 0 prints them in full. Reports, history and
+// other machine-readable output always keep full precision.
+var durationPrecision time.Duration
+
+func roundDuration(d time.Duration) time.Duration {
+	if durationPrecision <= 0 {
+		return d
 	}
+	return d.Round(durationPrecision)
+}
 
-	fmt.Println(strings.Repeat("-", 40))
-	if err == nil {
-		fmt.Printf("✓ [%d/%d - %.1f%%] %s completed in %v\n", current, total, progress, scriptName, duration)
-	} else {
-		fmt.Printf("✗ [%d/%d - %.1f%%] %s failed in %v: %v\n", current, total, progress, scriptName, duration, err)
+// statPrefix marks a structured line on a script's stdout, e.g.
+// "::stat requests=42 errors=1". Every key=value pair with a numeric value is
+// recorded on the result and summed across the whole run; api_ms also
+// becomes the result's APIDuration.
+const statPrefix = "::stat "
+
+// retryAfterStat is the stat a rate-limited script reports, in seconds, to
+// have its retry wait that long instead of the generic backoff, e.g.
+// "::stat retry_after=30". Unlike other stats the last value wins.
+const retryAfterStat = "retry_after"
+
+// maxRetryAfter caps a retry_after hint, so a bogus one can't stall the run.
+const maxRetryAfter = 15 * time.Minute
+
+func parseStatLine(line string, stats map[string]float64) bool {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), statPrefix)
+	if !ok {
+		return false
+	}
+	for _, field := range strings.Fields(rest) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if key == retryAfterStat {
+			stats[key] = n
+			continue
+		}
+		stats[key] += n
 	}
+	return true
+}
 
-	return result
+// lineWriter passes everything through to w while handing each complete line
+// to onLine.
+type lineWriter struct {
+	w      io.Writer
+	onLine func(string)
+	buf    []byte
 }
 
-func main() {
-	scriptDir := "."
-	if len(os.Args) > 1 {
-		scriptDir = os.Args[1]
-	}
-
-	// Working exchanges (17 total) - verified with TradingView
-	pythonScripts := []string{
-		//"bitmart.py",   // VERIFIED: BITMART exchange, keep_original format
-		"bitrue.py", // VERIFIED: BITRUE exchange, keep_original format
-		"btse.py",   // VERIFIED: BTSE exchange, remove_dash format
-		"bybit.py",  // VERIFIED: BYBIT exchange, keep_original format
-		//"coinbase.py",  // VERIFIED: COINBASE exchange, remove_dash format
-		"coinex.py",    // VERIFIED: COINEX exchange, keep_original format
-		"coinw.py",     // VERIFIED: COINW exchange, keep_original format
-		"cryptocom.py", // VERIFIED: CRYPTOCOM exchange, keep_original format
-		"gateio.py",    // VERIFIED: GATEIO exchange, keep_original format
-		"gemini.py",    // VERIFIED: GEMINI exchange, keep_original format
-		"htx.py",       // VERIFIED: HTX exchange, keep_original format
-		//"kraken.py",    // VERIFIED: KRAKEN exchange, keep_original format
-		"kucoin.py", // VERIFIED: KUCOIN exchange, remove_dash format
-		"mexc.py",   // VERIFIED: MEXC exchange, keep_original format
-		//"okx.py",       // VERIFIED: OKX exchange, remove_dash format
-		"whitebit.py", // VERIFIED: WHITEBIT exchange, keep_original format
-
-		// SKIPPED: Not available on TradingView (8 exchanges)
-		// "biconomy.py",      // Not available on TradingView
-		// "bigone.py",        // Not available on TradingView
-		// "deepcoin.py",      // Not available on TradingView
-		// "digifinex.py",     // Not available on TradingView
-		// "hashkeyglobal.py", // Not available on TradingView
-		// "lbank.py",         // Not available on TradingView
-		// "pionex.py",        // Not available on TradingView
-		// "toobit.py",        // Not available on TradingView
-	}
-
-	validScripts := []string{}
-	for _, script := range pythonScripts {
-		scriptPath := filepath.Join(scriptDir, script)
-		if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-			fmt.Printf("⚠ Skipping %s (file not found)\n", script)
-			continue
-		}
-		validScripts = append(validScripts, script)
-	}
-
-	fmt.Printf("Starting sequential execution of %d verified working Python scripts...\n", len(validScripts))
-	fmt.Println("=" + strings.Repeat("=", 60))
-
-	startTime := time.Now()
-	var scriptResults []ScriptResult
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.onLine(strings.TrimSuffix(string(lw.buf[:i]), "\r"))
+		lw.buf = lw.buf[i+1:]
+	}
+	return lw.w.Write(p)
+}
+
+func (lw *lineWriter) Flush() {
+	if len(lw.buf) > 0 {
+		lw.onLine(string(lw.buf))
+		lw.buf = nil
+	}
+}
 
-	for i, script := range validScripts {
-		scriptPath := filepath.Join(scriptDir, script)
-		result := runPythonScript(scriptPath, i+1, len(validScripts))
-		scriptResults = append(scriptResults, result)
-		
-		if i < len(validScripts)-1 {
-			fmt.Println()
+// liveLimiter lets only a script's first max lines of output through to the
+// console (-live-lines), keeping the last max of the hidden ones to show
+// once it ends. Logs, recordings and captured output are fed separately,
+// so they still get everything. Both streams count against one budget.
+type liveLimiter struct {
+	mu     sync.Mutex
+	max    int
+	shown  int
+	hidden int
+	tail   []string // last hidden lines, at most max
+	// flush hides each writer's unterminated last line, if hidden.
+	flush []func()
+}
+
+// writer returns the limited view of w; stdout and stderr each need their
+// own, as each tracks its current line. A line is shown or hidden whole,
+// decided when it starts.
+func (l *liveLimiter) writer(w io.Writer) io.Writer {
+	var partial []byte
+	lineStart, showing := true, false
+	l.flush = append(l.flush, func() {
+		if len(partial) > 0 {
+			l.hide(string(partial))
+		}
+	})
+	return writerFunc(func(p []byte) (int, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		n := len(p)
+		for len(p) > 0 {
+			if lineStart {
+				showing = l.shown < l.max
+				if showing {
+					l.shown++
+				}
+				lineStart = false
+			}
+			chunk := p
+			if i := bytes.IndexByte(p, '\n'); i >= 0 {
+				chunk, lineStart = p[:i+1], true
+			}
+			p = p[len(chunk):]
+			if showing {
+				w.Write(chunk)
+				continue
+			}
+			partial = append(partial, chunk...)
+			if lineStart {
+				l.hide(strings.TrimSuffix(string(partial), "\n"))
+				partial = nil
+			}
 		}
+		return n, nil
+	})
+}
+
+func (l *liveLimiter) hide(line string) {
+	l.hidden++
+	l.tail = append(l.tail, line)
+	if len(l.tail) > l.max {
+		l.tail = l.tail[1:]
 	}
+}
 
-	totalDuration := time.Since(startTime)
+// finish reports how much was held back and echoes its last lines.
+func (l *liveLimiter) finish(w io.Writer, logFile string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, flush := range l.flush {
+		flush()
+	}
+	if l.hidden == 0 {
+		return
+	}
+	where := ""
+	if logFile != "" {
+		where = ", all in " + logFile
+	}
+	fmt.Fprintf(w, "… %d more line(s) not shown live (-live-lines %d%s); last %d:\n", l.hidden, l.max, where, len(l.tail))
+	for _, line := range l.tail {
+		fmt.Fprintln(w, line)
+	}
+}
 
-	fmt.Println("\n" + strings.Repeat("=", 60))
-	fmt.Printf("Execution Summary (Total time: %v)\n", totalDuration)
-	fmt.Println(strings.Repeat("=", 60))
+// outputMatcher applies an exchange's successRegex/failureRegex to every
+// output line. Both stdout and stderr feed it, hence the lock.
+type outputMatcher struct {
+	mu          sync.Mutex
+	success     *regexp.Regexp
+	failure     *regexp.Regexp
+	successSeen bool
+	failureLine string
+}
 
-	successful := 0
-	failed := 0
-	var failedScripts []ScriptResult
+func (m *outputMatcher) line(line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.success != nil && !m.successSeen && m.success.MatchString(line) {
+		m.successSeen = true
+	}
+	if m.failure != nil && m.failureLine == "" && m.failure.MatchString(line) {
+		m.failureLine = line
+	}
+}
+
+// verdict overrides the exit-code based outcome err: a failureRegex match
+// always fails, and when a successRegex is set it alone decides success.
+func (m *outputMatcher) verdict(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.failureLine != "":
+		return fmt.Errorf("output matched failureRegex: %s", strings.TrimSpace(m.failureLine))
+	case m.success != nil && m.successSeen:
+		return nil
+	case m.success != nil && err == nil:
+		return fmt.Errorf("output never matched successRegex %q", m.success)
+	}
+	return err
+}
+
+// tailBuffer keeps the last max bytes written to it. It is shared by the
+// stdout and stderr copiers, hence the lock.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(p), nil
+}
+
+// reset drops everything written so far.
+func (t *tailBuffer) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = t.buf[:0]
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// outputTailSize bounds how much of a script's output is kept in memory;
+// the complete output goes to the -log-dir file when one is configured.
+const outputTailSize = 64 << 10
+
+// quietOutputSize bounds the whole-run output -quiet-success holds back.
+const quietOutputSize = 16 << 20
+
+// failureTailLines is how much output the summary shows per failed script.
+const failureTailLines = 20
+
+// lastTraceback returns the last Python traceback in output, from its
+// "Traceback (most recent call last):" line through the exception line, and
+// that exception line, which is empty when the output was cut off before it.
+func lastTraceback(output string) (traceback, exception string) {
+	lines := strings.Split(output, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Traceback (most recent call last):") {
+			start = i
+		}
+	}
+	if start < 0 {
+		return "", ""
+	}
+	for i, line := range lines[start+1:] {
+		line = strings.TrimRight(line, "\r")
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return strings.Join(lines[start:start+i+2], "\n"), line
+		}
+	}
+	return strings.Join(lines[start:], "\n"), ""
+}
+
+// tracebackSummary returns the exception line of the last Python traceback
+// in output, e.g. "ConnectionError: HTTPSConnectionPool(...)".
+func tracebackSummary(output string) string {
+	_, exception := lastTraceback(output)
+	return exception
+}
+
+// lastLines returns at most n trailing lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// failureRule is one entry of the -explain-failures table: a failure whose
+// error, exception or stderr tail matches pattern gets the category and
+// suggestion. %s in a suggestion is the exchange's environment prefix,
+// e.g. BYBIT.
+type failureRule struct {
+	category   string
+	pattern    *regexp.Regexp
+	suggestion string
+}
+
+// failureRules are tried in order; specific runner errors come before the
+// patterns scraped from Python output. Auth comes after rate limit and
+// network, since their messages often quote a URL with an api_key or
+// signature, and only HTTP 401/403 or an auth-specific error counts.
+var failureRules = []failureRule{
+	{"resource limit", regexp.MustCompile(`(cpu|memory) limit of .* exceeded|MemoryError`), "the script outgrew its cpuLimit/memLimit; raise the limit or look for a leak"},
+	{"stalled", regexp.MustCompile(`^stalled: `), "no output for a while; the exchange API may be hanging, or -stall-timeout is too short"},
+	{"timeout", regexp.MustCompile(`^timed out after `), "the script hit its timeout; the exchange may be slow today, or the timeout needs raising"},
+	{"cancelled", regexp.MustCompile(`^cancelled`), "the run was interrupted before the script finished; rerun it"},
+	{"docker", regexp.MustCompile(`^docker run failed`), "check the image name and that the docker daemon is running"},
+	{"interpreter", regexp.MustCompile(`^exec: .*executable file not found|^fork/exec .*: no such file or directory`), "the interpreter is missing; check -interpreter or -venv"},
+	{"output", regexp.MustCompile(`not valid JSON|does not match schema|produced only \d+ symbols`), "the script ran but its output looks wrong; the exchange API format may have changed"},
+	{"tls", regexp.MustCompile(`(?i)SSLError|CERTIFICATE_VERIFY_FAILED|certificate verify failed`), "TLS verification failed; check SSL_CERT_FILE and any intercepting -proxy"},
+	{"dependency missing", regexp.MustCompile(`ModuleNotFoundError|ImportError`), "a Python package is missing; install the requirements into the interpreter or -venv"},
+	{"rate limit", regexp.MustCompile(`(?i)\b429\b|too many requests|rate.?limit`), "the exchange is rate limiting; lower -parallel, set -max-rps or retry later"},
+	{"network", regexp.MustCompile(`(?i)ConnectionError|ConnectTimeout|ReadTimeout|timed out|Max retries exceeded|Name or service not known|getaddrinfo|Connection refused|Connection reset|\b50[234]\b`), "network problem; the exchange may be down or unreachable, check connectivity and proxy settings"},
+	{"auth", regexp.MustCompile(`(?i)\b40[13] (Client Error|Unauthori[sz]ed|Forbidden)\b|\b(status|code|status_code)["']?[ =:]+40[13]\b|\bunauthori[sz]ed\b|AuthenticationError|PermissionDenied|invalid (api[ _-]?key|signature)|api[ _-]?key (format )?(is )?invalid|signature (mismatch|verification failed|is invalid)`), "authentication failed; check %s_API_KEY and %s_API_SECRET"},
+	{"bad response", regexp.MustCompile(`JSONDecodeError|KeyError|IndexError`), "the API returned something unexpected (an error page or changed fields); the exchange API may have changed"},
+}
+
+// explainFailure returns the category and suggestion of the first rule
+// matching a failed result.
+func explainFailure(r ScriptResult) (category, suggestion string, ok bool) {
+	text := r.Summary + "\n" + lastLines(r.StderrText, failureTailLines)
+	var msg string
+	if r.Error != nil {
+		msg = r.Error.Error()
+	}
+	prefix := strings.ToUpper(r.Name)
+	for _, rule := range failureRules {
+		if rule.pattern.MatchString(msg) || rule.pattern.MatchString(text) {
+			return rule.category, strings.ReplaceAll(rule.suggestion, "%s", prefix), true
+		}
+	}
+	return "", "", false
+}
+
+func formatStats(stats map[string]float64) string {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + strconv.FormatFloat(stats[k], 'f', -1, 64)
+	}
+	return strings.Join(parts, " ")
+}
+
+// runOptions are the per-run settings that apply to every script.
+type runOptions struct {
+	Interpreter string
+	Venv        string        // absolute virtualenv path, activated for every script
+	Timeout     time.Duration // per script; 0 means no limit
+	KillGrace   time.Duration // time between SIGTERM and SIGKILL
+	LogDir      string        // per-script <name>.log files; empty disables
+	CPUSet      string        // taskset CPU list scripts are pinned to (-cpuset); empty leaves them unpinned
+	DockerImage string        // run scripts in this image via docker run (-docker); empty runs them on the host
+	// InteractiveStdin connects scripts to the runner's stdin instead of
+	// /dev/null.
+	InteractiveStdin bool
+	// MaskSecrets redacts secret environment values and MaskPatterns from
+	// script output before it reaches the console, logs, recordings and
+	// results.
+	MaskSecrets  bool
+	MaskPatterns []*regexp.Regexp
+	// OutputFormat is passed to scripts as OUTPUT_FORMAT and selects which
+	// data files are counted and validated.
+	OutputFormat string
+	// Proxy and NoProxy, when set, replace the proxy variables inherited
+	// from the runner's environment.
+	Proxy   string
+	NoProxy string
+	// CountOnly sets COUNT_ONLY=1, hinting that a script may skip the full
+	// scrape and just report how many symbols it would collect.
+	CountOnly bool
+	// PrintEnv dumps each script's masked environment before it starts.
+	PrintEnv bool
+	// LiveLines caps how many lines of each script's output are echoed to
+	// the console as they arrive; 0 echoes everything.
+	LiveLines int
+	// PrintCommand shows each script's masked command line and working
+	// directory before it starts.
+	PrintCommand bool
+	// StrictFormat fails a script whose output contradicts its declared
+	// symbol format instead of only warning.
+	StrictFormat bool
+	// RecordDir saves every run's output and exit status there; ReplayDir
+	// plays such recordings back instead of executing scripts, sleeping
+	// through the original gaps when ReplayTiming is set.
+	RecordDir    string
+	ReplayDir    string
+	ReplayTiming bool
+	// SuccessCodes are non-zero exit codes that count as success unless
+	// the exchange sets its own.
+	SuccessCodes []int
+	// StallTimeout kills a script that writes nothing for this long; 0
+	// disables the check.
+	StallTimeout time.Duration
+	// CompressLogs writes the LogDir files gzipped, as <name>.log.gz.
+	CompressLogs bool
+	// KeepPartial records a script that timed out or stalled after
+	// writing some output as partial instead of failed, and keeps that
+	// output.
+	KeepPartial bool
+	// AtomicOutput points scripts at a staging directory via OUTPUT_DIR
+	// and moves it over the real output directory only once the run has
+	// succeeded and validated.
+	AtomicOutput bool
+	// Budget, when set, is charged for the requests scripts report with
+	// "::stat requests=N" and holds back launches while it is overdrawn.
+	Budget *requestBudget
+}
+
+// requestBudget is a token bucket shared by all running scripts (-max-rps).
+// Scripts report requests after making them, so the bucket may go into
+// debt; a new launch waits until the debt is paid back.
+type requestBudget struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, also the burst size
+	tokens float64
+	last   time.Time
+}
+
+func newRequestBudget(rate float64) *requestBudget {
+	return &requestBudget{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// refill must be called with b.mu held.
+func (b *requestBudget) refill() {
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+b.rate*now.Sub(b.last).Seconds())
+	b.last = now
+}
+
+func (b *requestBudget) spend(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= n
+}
+
+// wait blocks until the bucket is out of debt and returns how long it
+// waited.
+func (b *requestBudget) wait(ctx context.Context) time.Duration {
+	var waited time.Duration
+	for {
+		b.mu.Lock()
+		b.refill()
+		debt := -b.tokens
+		b.mu.Unlock()
+		if debt <= 0 {
+			return waited
+		}
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+			return waited + time.Since(start)
+		case <-time.After(time.Duration(debt / b.rate * float64(time.Second))):
+		}
+		waited += time.Since(start)
+	}
+}
+
+// errStalled is the cancellation cause of a script killed by StallTimeout.
+var errStalled = errors.New("no output within stall timeout")
+
+// retryPolicy decides whether and when a failed script is run again.
+type retryPolicy struct {
+	Retries int           // extra attempts after the first
+	Delay   time.Duration // base backoff, doubled per attempt
+	// Jitter randomises the backoff so that exchanges failing together
+	// don't retry in lockstep: "none", "full" (uniform in [0, backoff)) or
+	// "decorrelated" (uniform in [Delay, 3*previous sleep], capped).
+	Jitter string
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+var retryJitters = []string{"none", "full", "decorrelated"}
+
+const maxRetryDelay = 5 * time.Minute
+
+// newRetryPolicy seeds the jitter source; a non-zero seed makes the delays
+// reproducible.
+func newRetryPolicy(retries int, delay time.Duration, jitter string, seed uint64) *retryPolicy {
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return &retryPolicy{
+		Retries: retries,
+		Delay:   delay,
+		Jitter:  jitter,
+		rng:     rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// backoff returns the sleep before retry number attempt (1-based), given the
+// previous sleep for the same exchange.
+func (p *retryPolicy) backoff(attempt int, prev time.Duration) time.Duration {
+	// Doubling stops at the cap, so a high attempt can't overflow the shift.
+	exp := p.Delay
+	for i := 1; i < attempt && exp < maxRetryDelay; i++ {
+		exp <<= 1
+	}
+	exp = min(exp, maxRetryDelay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.Jitter {
+	case "full":
+		return time.Duration(p.rng.Int64N(int64(exp) + 1))
+	case "decorrelated":
+		upper := max(3*prev, p.Delay)
+		return min(p.Delay+time.Duration(p.rng.Int64N(int64(upper-p.Delay)+1)), maxRetryDelay)
+	default:
+		return exp
+	}
+}
+
+// runWithRetries runs a script until it succeeds, the retries are used up or
+// ctx is cancelled.
+func runWithRetries(ctx context.Context, scriptDir string, ex Exchange, opts runOptions, retry *retryPolicy, current, total int) ScriptResult {
+	var sleep time.Duration
+	var attempts []Attempt
+	retries := retry.Retries
+	if ex.Retries != nil {
+		retries = *ex.Retries
+	}
+	for attempt := 0; ; attempt++ {
+		if opts.Budget != nil {
+			if waited := opts.Budget.wait(ctx); waited > 0 {
+				slog.Info("request budget overdrawn; launch held back", "exchange", ex.Name, "waited", waited)
+			}
+		}
+		started := time.Now()
+		result := runScript(ctx, scriptDir, ex, opts, current, total)
+		a := Attempt{Number: attempt + 1, Started: started, Duration: result.Duration, ExitCode: result.exitCode}
+		if result.Error != nil {
+			a.Error = result.Error.Error()
+		}
+		attempts = append(attempts, a)
+		result.Attempts, result.Retries = attempts, retries
+		if result.Success || result.Partial || result.permanent || attempt >= retries || ctx.Err() != nil {
+			return result
+		}
+		// The backoff sequence goes on underneath a hint, so a later
+		// attempt without one continues where it would have.
+		sleep = retry.backoff(attempt+1, sleep)
+		delay, hinted := sleep, ""
+		if after := result.Stats[retryAfterStat]; after > 0 {
+			delay = min(time.Duration(after*float64(time.Second)), maxRetryAfter)
+			hinted = ", as the script asked"
+		}
+		fmt.Fprintf(console, "🔁 Retrying %s in %v%s (attempt %d/%d)\n", ex.Name, delay.Round(time.Millisecond), hinted, attempt+2, retries+1)
+		slog.Info("retrying script", "exchange", ex.Name, "attempt", attempt+2, "delay", delay, "retry_after", hinted != "", "error", result.Error)
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(delay):
+		}
+	}
+}
+
+// setEnv sets key in a KEY=value environment list, replacing any existing
+// entry.
+func setEnv(env []string, key, value string) []string {
+	prefix := key + "="
+	env = slices.DeleteFunc(env, func(kv string) bool { return strings.HasPrefix(kv, prefix) })
+	return append(env, prefix+value)
+}
+
+// essentialEnv is always forwarded, even to exchanges that restrict their
+// environment with envPassthrough.
+var essentialEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TZ", "TMPDIR", "TEMP", "TMP",
+	"LANG", "LC_*", "PYTHON*", "SSL_CERT_*", "REQUESTS_CA_BUNDLE",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// filterEnv keeps the variables whose names match one of the glob patterns.
+func filterEnv(env, patterns []string) []string {
+	return slices.DeleteFunc(slices.Clone(env), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		return !slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		})
+	})
+}
+
+// scriptEnv is the environment a script is started with. By default the
+// whole runner environment is inherited; an exchange with envPassthrough only
+// gets essentialEnv plus the matching variables. The exchange's explicit env
+// entries are applied last and win over everything else.
+func scriptEnv(opts runOptions, ex Exchange) []string {
+	env := os.Environ()
+	if len(ex.EnvPassthrough) > 0 {
+		env = filterEnv(env, append(slices.Clone(essentialEnv), ex.EnvPassthrough...))
+	}
+	// Python block-buffers stdout when it is not a terminal; keep it live.
+	env = setEnv(env, "PYTHONUNBUFFERED", "1")
+	env = setEnv(env, "OUTPUT_FORMAT", opts.OutputFormat)
+	env = setEnv(env, "OUTPUT_ENCODING", cmp.Or(ex.OutputEncoding, "plain"))
+	if opts.CountOnly {
+		env = setEnv(env, "COUNT_ONLY", "1")
+	}
+	// Python's requests/urllib read either case; set both so a stale
+	// inherited variable of the other case can't win.
+	if opts.Proxy != "" {
+		for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			env = setEnv(env, key, opts.Proxy)
+		}
+	}
+	if opts.NoProxy != "" {
+		env = setEnv(env, "NO_PROXY", opts.NoProxy)
+		env = setEnv(env, "no_proxy", opts.NoProxy)
+	}
+	if opts.Venv != "" {
+		// Mirror what bin/activate does.
+		env = setEnv(env, "VIRTUAL_ENV", opts.Venv)
+		env = setEnv(env, "PATH", filepath.Join(opts.Venv, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+		env = slices.DeleteFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "PYTHONHOME=") })
+	}
+	for _, key := range slices.Sorted(maps.Keys(ex.Env)) {
+		env = setEnv(env, key, ex.Env[key])
+	}
+	return env
+}
+
+// secretMarkers flag environment variables whose values -print-env masks.
+var secretMarkers = []string{"KEY", "SECRET", "TOKEN", "PASSWORD", "PASSWD", "PASS", "CREDENTIAL", "AUTH", "PRIVATE", "SIGNATURE"}
+
+func isSecretName(name string) bool {
+	upper := strings.ToUpper(name)
+	return slices.ContainsFunc(secretMarkers, func(m string) bool { return strings.Contains(upper, m) })
+}
+
+// maskEnv returns a KEY=value entry safe to print: secret-looking values
+// are replaced and passwords embedded in URLs (proxies) are redacted.
+func maskEnv(kv string) string {
+	key, value, _ := strings.Cut(kv, "=")
+	if isSecretName(key) && value != "" {
+		return key + "=****"
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return key + "=" + u.Redacted()
+	}
+	return kv
+}
+
+// minSecretLength keeps -mask-secrets from masking short values such as
+// "1" or "yes" wherever they appear in the output.
+const minSecretLength = 6
+
+// secretMask replaces every secret -mask-secrets finds.
+const secretMask = "****"
+
+// secretMasker redacts secrets from a script's output (-mask-secrets): the
+// values of its secret-looking environment variables and the config's
+// maskPatterns.
+type secretMasker struct {
+	values   []string
+	patterns []*regexp.Regexp
+}
+
+func newSecretMasker(patterns []*regexp.Regexp, env []string) *secretMasker {
+	m := &secretMasker{patterns: patterns}
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if isSecretName(key) && len(value) >= minSecretLength {
+			m.values = append(m.values, value)
+		}
+	}
+	// Longest first, so a secret containing another is masked whole.
+	slices.SortFunc(m.values, func(a, b string) int { return len(b) - len(a) })
+	return m
+}
+
+func compileMaskPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("maskPatterns %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (m *secretMasker) mask(s string) string {
+	for _, v := range m.values {
+		s = strings.ReplaceAll(s, v, secretMask)
+	}
+	for _, re := range m.patterns {
+		s = re.ReplaceAllLiteralString(s, secretMask)
+	}
+	return s
+}
+
+// maskingWriter passes whole lines through the masker to w, so a secret
+// split across writes is still caught. A trailing partial line waits for
+// the next newline or Flush.
+type maskingWriter struct {
+	masker *secretMasker
+	w      io.Writer
+	buf    []byte
+}
+
+func (mw *maskingWriter) Write(p []byte) (int, error) {
+	mw.buf = append(mw.buf, p...)
+	i := bytes.LastIndexByte(mw.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	out := mw.masker.mask(string(mw.buf[:i+1]))
+	mw.buf = append(mw.buf[:0], mw.buf[i+1:]...)
+	if _, err := io.WriteString(mw.w, out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (mw *maskingWriter) Flush() {
+	if len(mw.buf) > 0 {
+		io.WriteString(mw.w, mw.masker.mask(string(mw.buf)))
+		mw.buf = nil
+	}
+}
+
+// printEnv writes the environment a script will get, sorted and masked.
+func printEnv(w io.Writer, ex Exchange, env []string) {
+	fmt.Fprintf(w, "🔧 Environment for %s:\n", ex.Name)
+	for _, kv := range slices.Sorted(slices.Values(env)) {
+		fmt.Fprintf(w, "    %s\n", maskEnv(kv))
+	}
+}
+
+// printCommand writes the command a script will be started with, quoted
+// for a POSIX shell, and its working directory. Secret KEY=value arguments
+// and any argument containing a secret from env or a mask pattern are
+// masked.
+func printCommand(w io.Writer, ex Exchange, cmd *exec.Cmd, patterns []*regexp.Regexp) {
+	masker := newSecretMasker(patterns, cmd.Env)
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = shellQuote(masker.mask(maskEnv(arg)))
+	}
+	// Args[0] is the name as given; Path is what PATH lookup found.
+	args[0] = shellQuote(cmd.Path)
+	fmt.Fprintf(w, "🔧 Command for %s:\n    cd %s && %s\n", ex.Name, shellQuote(cmd.Dir), strings.Join(args, " "))
+	var limits []string
+	if ex.CPULimit > 0 {
+		limits = append(limits, fmt.Sprintf("cpu %v", ex.CPULimit))
+	}
+	if ex.MemLimit > 0 {
+		limits = append(limits, fmt.Sprintf("memory %d MiB", ex.MemLimit>>20))
+	}
+	if len(limits) > 0 {
+		fmt.Fprintf(w, "    with limits: %s\n", strings.Join(limits, ", "))
+	}
+}
+
+// shellQuote single-quotes s unless it only has characters a shell leaves
+// alone.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// venvInterpreter validates a virtualenv directory and returns its python.
+func venvInterpreter(venv string) (string, error) {
+	for _, name := range []string{"python3", "python"} {
+		path := filepath.Join(venv, "bin", name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("-venv %s: no bin/python3 or bin/python found", venv)
+}
+
+func runScript(ctx context.Context, scriptDir string, ex Exchange, opts runOptions, current int, total int) ScriptResult {
+	start := time.Now()
+	scriptPath := filepath.Join(scriptDir, ex.Script)
+	scriptName := ex.Name
 
-	for _, result := range scriptResults {
-		if result.Success {
-			fmt.Printf("✓ %-15s - %v\n", result.Name, result.Duration)
-			successful++
+	progress := float64(current) / float64(total) * 100
+	fmt.Fprintf(console, "🔄 [%d/%d - %.1f%%] Starting %s...\n", current, total, progress, scriptName)
+	fmt.Fprintf(console, "📋 Output from %s:\n", scriptName)
+	fmt.Fprintln(console, strings.Repeat("-", 40))
+
+	slog.Info("script started", "exchange", scriptName, "script", scriptPath)
+
+	captured := &tailBuffer{max: outputTailSize}
+	stdoutTail := &tailBuffer{max: outputTailSize}
+	stderrTail := &tailBuffer{max: outputTailSize}
+	liveOut, liveErr := console, scriptStderr
+	var live *liveLimiter
+	if opts.LiveLines > 0 {
+		live = &liveLimiter{max: opts.LiveLines}
+		liveOut, liveErr = live.writer(console), live.writer(scriptStderr)
+	}
+	stdoutSink := io.MultiWriter(liveOut, captured, stdoutTail)
+	stderrSink := io.MultiWriter(liveErr, captured, stderrTail)
+	var logFile string
+	if opts.LogDir != "" {
+		logFile = filepath.Join(opts.LogDir, scriptName+".log")
+		if opts.CompressLogs {
+			logFile += ".gz"
+		}
+		f, err := os.Create(logFile)
+		if err != nil {
+			slog.Warn("cannot create log file", "exchange", scriptName, "path", logFile, "error", err)
+			logFile = ""
 		} else {
-			fmt.Printf("✗ %-15s - %v (ERROR)\n", result.Name, result.Duration)
-			failedScripts = append(failedScripts, result)
-			failed++
+			defer f.Close()
+			var w io.Writer = f
+			if opts.CompressLogs {
+				// Deferred after f.Close, so it runs first: the gzip
+				// trailer is written on every return path.
+				gz := gzip.NewWriter(f)
+				defer func() {
+					if err := gz.Close(); err != nil {
+						slog.Warn("cannot finish compressed log", "exchange", scriptName, "path", logFile, "error", err)
+					}
+				}()
+				w = gz
+			}
+			stdoutSink = io.MultiWriter(stdoutSink, w)
+			stderrSink = io.MultiWriter(stderrSink, w)
+		}
+	}
+
+	stats := map[string]float64{}
+	matcher := &outputMatcher{success: ex.SuccessRegex, failure: ex.FailureRegex}
+	stdout := &lineWriter{w: stdoutSink, onLine: func(line string) {
+		before := stats["requests"]
+		if parseStatLine(line, stats) && opts.Budget != nil && stats["requests"] > before {
+			opts.Budget.spend(stats["requests"] - before)
+		}
+		matcher.line(line)
+	}}
+	stderr := &lineWriter{w: stderrSink, onLine: matcher.line}
+
+	timeout := opts.Timeout
+	if ex.Timeout > 0 {
+		timeout = ex.Timeout
+	}
+	scriptCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		scriptCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// The stall watchdog is pushed back by every write on either stream.
+	activity := func() {}
+	if opts.StallTimeout > 0 {
+		var cancel context.CancelCauseFunc
+		scriptCtx, cancel = context.WithCancelCause(scriptCtx)
+		defer cancel(nil)
+		watchdog := time.AfterFunc(opts.StallTimeout, func() { cancel(errStalled) })
+		defer watchdog.Stop()
+		activity = func() { watchdog.Reset(opts.StallTimeout) }
+	}
+
+	env := scriptEnv(opts, ex)
+	outputDir := filepath.Join(scriptDir, ex.OutputDir())
+	var staging string
+	if opts.AtomicOutput {
+		staging = stagingDir(outputDir)
+		os.RemoveAll(staging)
+		if abs, err := filepath.Abs(staging); err == nil {
+			staging = abs
 		}
+		env = setEnv(env, "OUTPUT_DIR", staging)
 	}
 
-	fmt.Println(strings.Repeat("-", 60))
-	fmt.Printf("Results: %d successful, %d failed\n", successful, failed)
+	var name, container string
+	var args []string
+	if image := cmp.Or(ex.Image, opts.DockerImage); image != "" {
+		var err error
+		name, args, container, err = dockerCommand(image, scriptDir, ex, opts, env)
+		if err != nil {
+			return ScriptResult{Name: scriptName, Error: err, Format: ex.Format, permanent: true, exitCode: -1}
+		}
+	} else {
+		name, args = pinnedCommand(opts, opts.Interpreter, []string{scriptPath})
+	}
+	cmd := exec.CommandContext(scriptCtx, name, args...)
+	cmd.Dir = filepath.Dir(scriptPath)
+	// The script gets its own process group so that termination reaches any
+	// children it spawned. On timeout or cancellation the group is asked to
+	// stop first, so it can flush partial output, and is SIGKILLed once the
+	// grace period runs out, even if it traps or ignores SIGTERM.
+	//
+	// Stdin is /dev/null, so a script that prompts gets EOF and fails fast
+	// instead of hanging an unattended run. With -interactive-stdin it reads
+	// the terminal instead and stays in the runner's process group, since a
+	// background group reading the terminal would be stopped by SIGTTIN; only
+	// the script itself is then signalled.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = nil
+	group := func() int { return -cmd.Process.Pid }
+	if opts.InteractiveStdin {
+		cmd.SysProcAttr.Setpgid = false
+		cmd.Stdin = os.Stdin
+		group = func() int { return cmd.Process.Pid }
+	}
+	var escalate *time.Timer
+	cmd.Cancel = func() error {
+		slog.Info("terminating script", "exchange", scriptName, "reason", context.Cause(scriptCtx), "grace", opts.KillGrace)
+		pid := group()
+		escalate = time.AfterFunc(opts.KillGrace, func() {
+			slog.Warn("script ignored SIGTERM; sending SIGKILL", "exchange", scriptName)
+			syscall.Kill(pid, syscall.SIGKILL)
+		})
+		return syscall.Kill(pid, syscall.SIGTERM)
+	}
+	// Backstop for Wait itself: stop waiting on output pipes held open by
+	// stray grandchildren shortly after the group was killed.
+	cmd.WaitDelay = opts.KillGrace + time.Second
+	cmd.Env = env
+	if opts.PrintEnv {
+		printEnv(console, ex, cmd.Env)
+	}
+	if opts.PrintCommand {
+		printCommand(console, ex, cmd, opts.MaskPatterns)
+	}
+	if container == "" {
+		if err := limitCommand(cmd, ex); err != nil {
+			return ScriptResult{Name: scriptName, Error: err, Format: ex.Format, permanent: true, exitCode: -1}
+		}
+	}
+
+	var stdoutW, stderrW io.Writer = stdout, stderr
+	var rec *recording
+	if opts.RecordDir != "" {
+		rec = &recording{Name: scriptName, start: start}
+		stdoutW = io.MultiWriter(stdoutW, rec.stream("stdout"))
+		stderrW = io.MultiWriter(stderrW, rec.stream("stderr"))
+	}
+	flushMasked := func() {}
+	if opts.MaskSecrets {
+		masker := newSecretMasker(opts.MaskPatterns, cmd.Env)
+		maskedOut := &maskingWriter{masker: masker, w: stdoutW}
+		maskedErr := &maskingWriter{masker: masker, w: stderrW}
+		stdoutW, stderrW = maskedOut, maskedErr
+		flushMasked = func() { maskedOut.Flush(); maskedErr.Flush() }
+	}
+	cmd.Stdout = writerFunc(func(p []byte) (int, error) { activity(); return stdoutW.Write(p) })
+	cmd.Stderr = writerFunc(func(p []byte) (int, error) { activity(); return stderrW.Write(p) })
+
+	var err error
+	var replayed *recording
+	if opts.ReplayDir != "" {
+		replayed, err = loadRecording(opts.ReplayDir, scriptName)
+		if err == nil {
+			err = replayed.play(scriptCtx, cmd.Stdout, cmd.Stderr, opts.ReplayTiming)
+		}
+	} else {
+		err = cmd.Run()
+	}
+	if escalate != nil {
+		escalate.Stop()
+		// Reap whatever is left of the group once the leader is gone.
+		syscall.Kill(group(), syscall.SIGKILL)
+	}
+	if container != "" && escalate != nil {
+		// A killed docker client leaves its container running.
+		exec.Command("docker", "rm", "-f", container).Run()
+	}
+	if rec != nil {
+		rec.setResult(err)
+	}
+	exitCode := exitCodeOf(err)
+	flushMasked()
+	stdout.Flush()
+	stderr.Flush()
+	if live != nil {
+		live.finish(console, logFile)
+	}
+	flushOutput()
+	duration := time.Since(start)
+	var timedOut bool // by -timeout or -stall-timeout, which -keep-partial forgives
+	switch {
+	case err == nil:
+	case errors.Is(context.Cause(scriptCtx), errStalled) && ctx.Err() == nil:
+		timedOut = true
+		err = fmt.Errorf("stalled: no output for %v: %w", opts.StallTimeout, err)
+		slog.Warn("script stalled", "exchange", scriptName, "stall_timeout", opts.StallTimeout)
+	case errors.Is(scriptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		timedOut = true
+		err = fmt.Errorf("timed out after %v: %w", timeout, err)
+		slog.Warn("script timed out", "exchange", scriptName, "timeout", timeout)
+	case ctx.Err() != nil:
+		if cause := context.Cause(ctx); cause != ctx.Err() {
+			err = fmt.Errorf("cancelled: %v: %w", cause, err)
+		} else {
+			err = fmt.Errorf("cancelled: %w", err)
+		}
+	case container != "" && exitCode == dockerRunFailed:
+		err = fmt.Errorf("docker run failed: %w", err)
+	default:
+		if reason := limitExceeded(ex, err, stderrTail.String()); reason != "" {
+			err = fmt.Errorf("%s: %w", reason, err)
+			slog.Warn("script hit resource limit", "exchange", scriptName, "reason", reason)
+		}
+	}
 
-	if len(failedScripts) > 0 {
-		fmt.Println("\nFailed Scripts Details:")
-		fmt.Println(strings.Repeat("-", 60))
-		for _, result := range failedScripts {
-			fmt.Printf("\n%s:\n", result.Name)
-			fmt.Printf("Error: %v\n", result.Error)
-			if len(result.Output) > 0 {
-				fmt.Printf("Output:\n%s\n", result.Output)
+	var skipped, permanent bool
+	if scriptCtx.Err() == nil {
+		successCodes := opts.SuccessCodes
+		if ex.SuccessCodes != nil {
+			successCodes = ex.SuccessCodes
+		}
+		switch exitStatus(ex, exitCode, successCodes) {
+		case "success":
+			err = nil
+		case "skip":
+			err, skipped = nil, true
+		case "failure":
+			permanent = true
+			fallthrough
+		case "retry":
+			if err == nil {
+				err = errors.New("exit status 0 is mapped to failure")
 			}
 		}
+		if !skipped {
+			err = matcher.verdict(err)
+		}
+	}
+	if container != "" && exitCode == dockerRunFailed {
+		permanent = true // retrying won't fix a missing image or bad option
 	}
 
-	if failed > 0 {
-		os.Exit(1)
+	result := ScriptResult{
+		Name:       scriptName,
+		Success:    err == nil,
+		Duration:   duration,
+		Error:      err,
+		Output:     captured.String(),
+		StdoutText: stdoutTail.String(),
+		StderrText: stderrTail.String(),
+		Stats:      stats,
+		LogFile:    logFile,
+		Format:     ex.Format,
+		Skipped:    skipped,
+		permanent:  permanent,
+		exitCode:   exitCode,
+	}
+
+	published := outputDir
+	if staging != "" {
+		if info, err := os.Stat(staging); err == nil && info.IsDir() {
+			outputDir = staging
+		} else {
+			slog.Debug("script ignored OUTPUT_DIR; output written in place", "exchange", scriptName)
+			staging = ""
+		}
+	}
+	if replayed != nil {
+		// The data files are not part of a recording; restore what they
+		// amounted to.
+		duration = replayed.Duration
+		result.Duration, result.Symbols, result.Checksum = replayed.Duration, replayed.Symbols, replayed.Checksum
+	} else {
+		if symbols, err := readSymbols(outputDir, opts.OutputFormat); err == nil {
+			result.Symbols = len(symbols)
+			result.FormatMismatch = formatMismatch(ex.Format, symbols)
+		}
+		if result.FormatMismatch != "" && result.Success && !skipped && opts.StrictFormat {
+			result.Success = false
+			result.Error = fmt.Errorf("symbol format: %s", result.FormatMismatch)
+		}
+		if result.Success && !skipped {
+			if err := validateOutput(outputDir, opts.OutputFormat, schemaPath(scriptDir, ex)); err != nil {
+				result.Success = false
+				result.Error = err
+			}
+		}
+		if sum, err := outputChecksum(outputDir); err == nil {
+			result.Checksum = sum
+		}
+	}
+	if timedOut && opts.KeepPartial && result.Symbols > 0 {
+		result.Partial = true
+		slog.Warn("keeping partial output", "exchange", scriptName, "symbols", result.Symbols)
+	}
+	if staging != "" {
+		if (result.Success && !skipped) || result.Partial {
+			if err := publishDir(staging, published); err != nil {
+				result.Success = false
+				result.Error = fmt.Errorf("publish output: %w", err)
+			}
+		} else {
+			os.RemoveAll(staging)
+			slog.Info("discarded staged output of failed run", "exchange", scriptName)
+		}
+	}
+	if rec != nil {
+		rec.Duration, rec.Symbols, rec.Checksum = duration, result.Symbols, result.Checksum
+		if err := rec.save(opts.RecordDir); err != nil {
+			slog.Error("failed to save recording", "exchange", scriptName, "dir", opts.RecordDir, "error", err)
+		}
+	}
+	if result.Symbols > 0 {
+		result.MsPerSymbol = float64(duration.Milliseconds()) / float64(result.Symbols)
+	}
+	if ms, ok := stats["api_ms"]; ok {
+		result.APIDuration = time.Duration(ms * float64(time.Millisecond))
+	}
+
+	if result.Success && !skipped && ex.MinSymbols > 0 && result.Symbols < ex.MinSymbols {
+		result.Success = false
+		result.Error = fmt.Errorf("produced only %d symbols, expected >= %d", result.Symbols, ex.MinSymbols)
+	}
+	err = result.Error
+
+	// Captured output only serves failure diagnostics; don't carry it for
+	// successful scripts into reports.
+	if result.Success {
+		result.Output, result.StdoutText, result.StderrText = "", "", ""
+	} else {
+		result.Summary = tracebackSummary(result.StderrText)
+	}
+
+	slog.Info("script finished", "exchange", scriptName, "success", result.Success,
+		"duration", duration, "symbols", result.Symbols, "error", err)
+
+	fmt.Fprintln(console, strings.Repeat("-", 40))
+	if skipped {
+		fmt.Fprintf(console, "⏭ [%d/%d - %.1f%%] %s skipped itself in %v\n", current, total, progress, scriptName, roundDuration(duration))
+	} else if err == nil {
+		fmt.Fprintf(console, "✓ [%d/%d - %.1f%%] %s completed in %v (%d symbols)\n", current, total, progress, scriptName, roundDuration(duration), result.Symbols)
+	} else {
+		mark, verb := "✗", "failed"
+		if result.Partial {
+			mark, verb = "◐", "kept partial output"
+		}
+		fmt.Fprintf(console, "%s [%d/%d - %.1f%%] %s %s in %v: %v\n", mark, current, total, progress, scriptName, verb, roundDuration(duration), err)
+	}
+
+	return result
+}
+
+// Exchange describes one scraper script in the roster.
+type Exchange struct {
+	Name    string
+	Script  string
+	Format  string
+	Enabled bool
+	Note    string
+	// Output is the directory holding one data file per collected symbol,
+	// relative to the script directory. Defaults to data_<name>_1d.
+	Output string
+	// SuccessRegex and FailureRegex judge a run by its output instead of its
+	// exit code, for scripts whose exit status is unreliable.
+	SuccessRegex *regexp.Regexp
+	FailureRegex *regexp.Regexp
+	// EnvPassthrough restricts the inherited environment to essentialEnv
+	// plus variables matching these globs (e.g. "BYBIT_*"); Env sets
+	// explicit variables on top.
+	EnvPassthrough []string
+	Env            map[string]string
+	// MinSymbols fails an otherwise successful run that collected fewer
+	// symbols; 0 disables the check.
+	MinSymbols int
+	// Priority sorts the plan: higher comes first, ties keep -config order,
+	// then roster order. Scripts are started in plan order, except that one
+	// waiting on its dependencies or on a full host group is passed over
+	// meanwhile.
+	Priority int
+	// Host groups exchanges that share infrastructure (API host, CDN) so
+	// that -max-concurrency-per-host can limit how many of them run at
+	// once. Empty means the exchange is its own group.
+	Host string
+	// CPULimit and MemLimit cap the script's CPU time and address space
+	// (RLIMIT_CPU, RLIMIT_AS); zero leaves them unlimited.
+	CPULimit time.Duration
+	MemLimit int64
+	// ExitCodes classifies exit codes for scripts with their own
+	// conventions; see exitStatuses. Unlisted codes keep the default: 0
+	// succeeds, anything else fails and may be retried.
+	ExitCodes map[int]string
+	// Tags are free-form labels ("spot", "us") for -tag selection.
+	Tags []string
+	// SuccessCodes, when non-nil, replaces -success-codes for this
+	// exchange.
+	SuccessCodes []int
+	// Retries, when non-nil, replaces -retries for this exchange; 0 never
+	// retries it.
+	Retries *int
+	// Timeout, when non-zero, replaces -timeout for this exchange; set from
+	// config, an inline annotation or -adaptive-timeout.
+	Timeout time.Duration
+	// DependsOn names exchanges that must finish first; if one of them
+	// fails this exchange is skipped.
+	DependsOn []string
+	// OutputEncoding is passed to the script as OUTPUT_ENCODING: "gzip"
+	// asks for <SYMBOL>_1d.<format>.gz files; empty means plain.
+	OutputEncoding string
+	// Schema is a JSON Schema file, relative to the script directory, that
+	// every data file must match under -output-format json.
+	Schema string
+	// Image, when set, runs the script in this container image instead of
+	// -docker's, or instead of on the host.
+	Image string
+	// Normalize refines Format when symbols are compared across exchanges
+	// or merged; nil leaves Format alone.
+	Normalize *normalizeRules
+}
+
+// normalizeRules is a config "normalize" block. The steps run in field
+// order: regular expression replacements, upper-casing, renaming the quote
+// asset, then stripping separators.
+type normalizeRules struct {
+	Replace   []normalizeReplace `json:"replace,omitempty"`
+	Uppercase bool               `json:"uppercase,omitempty"`
+	// QuoteAliases renames a trailing quote asset, e.g. {"USD": "USDT"};
+	// none are applied by default. In a symbol with a -, _ or / separator
+	// only the part after the last one is matched, otherwise the longest
+	// matching suffix is, so choose aliases that don't end other quotes
+	// (USD is a suffix of BUSD).
+	QuoteAliases map[string]string `json:"quoteAliases,omitempty"`
+	Strip        []string          `json:"strip,omitempty"` // separators to remove, e.g. ["_", "/"]
+}
+
+type normalizeReplace struct {
+	Pattern string `json:"pattern"`
+	With    string `json:"with"` // may refer to groups as ${1}
+	re      *regexp.Regexp
+}
+
+// compile checks the rules and compiles their patterns.
+func (n *normalizeRules) compile() error {
+	for i := range n.Replace {
+		re, err := regexp.Compile(n.Replace[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("replace: %w", err)
+		}
+		n.Replace[i].re = re
+	}
+	if slices.Contains(n.Strip, "") {
+		return errors.New("strip: empty separator")
+	}
+	return nil
+}
+
+func (n *normalizeRules) apply(symbol string) string {
+	for _, r := range n.Replace {
+		symbol = r.re.ReplaceAllString(symbol, r.With)
+	}
+	if n.Uppercase {
+		symbol = strings.ToUpper(symbol)
+	}
+	if len(n.QuoteAliases) > 0 {
+		base, quote := "", symbol
+		if i := strings.LastIndexAny(symbol, "-_/"); i >= 0 {
+			base, quote = symbol[:i+1], symbol[i+1:]
+		}
+		if to, ok := n.QuoteAliases[quote]; ok && base != "" {
+			symbol = base + to
+		} else if base == "" {
+			// Longest first, so {"USD", "USDT"} never reads BTCUSDT as
+			// BTC + USD + T.
+			for _, from := range slices.SortedFunc(maps.Keys(n.QuoteAliases), func(a, b string) int { return len(b) - len(a) }) {
+				if len(symbol) > len(from) && strings.HasSuffix(symbol, from) {
+					symbol = strings.TrimSuffix(symbol, from) + n.QuoteAliases[from]
+					break
+				}
+			}
+		}
+	}
+	for _, sep := range n.Strip {
+		symbol = strings.ReplaceAll(symbol, sep, "")
+	}
+	return symbol
+}
+
+// exitStatuses are the values an ExitCodes entry may take. "failure" fails
+// without retrying; "retry" fails and is retried under -retries.
+var exitStatuses = []string{"success", "failure", "skip", "retry"}
+
+// HostGroup is the key -max-concurrency-per-host counts against.
+func (ex Exchange) HostGroup() string {
+	if ex.Host != "" {
+		return ex.Host
+	}
+	return ex.Name
+}
+
+// config is the optional JSON file given with -config. Its exchange entries
+// are merged onto the built-in roster by name; entries with new names are
+// appended in config order.
+type config struct {
+	// Extends names a base config, relative to this file, that this one
+	// overlays; readConfig resolves it, so it is always empty here.
+	Extends string `json:"extends,omitempty"`
+	// Proxy and NoProxy are exported to every script unless -proxy or
+	// -no-proxy override them.
+	Proxy   string `json:"proxy,omitempty"`
+	NoProxy string `json:"noProxy,omitempty"`
+	// MaskPatterns are regular expressions -mask-secrets redacts from
+	// script output, in addition to secret environment values.
+	MaskPatterns []string `json:"maskPatterns,omitempty"`
+	// Normalize applies to every exchange without its own normalize block.
+	Normalize *normalizeRules `json:"normalize,omitempty"`
+	// RequiredExchanges fail the run whenever one of them fails, whatever
+	// -fail-threshold-percent allows; -require adds to them.
+	RequiredExchanges []string         `json:"requiredExchanges,omitempty"`
+	Exchanges         []exchangeConfig `json:"exchanges"`
+}
+
+// exchangeConfig uses pointers so that only the fields present in the file
+// override the roster.
+type exchangeConfig struct {
+	Name           string            `json:"name"`
+	Script         *string           `json:"script,omitempty"`
+	Format         *string           `json:"format,omitempty"`
+	Enabled        *envBool          `json:"enabled,omitempty"`
+	Note           *string           `json:"note,omitempty"`
+	Output         *string           `json:"output,omitempty"`
+	Priority       *int              `json:"priority,omitempty"`
+	Host           *string           `json:"host,omitempty"`
+	CPULimit       *string           `json:"cpuLimit,omitempty"` // duration, e.g. "10m"
+	MemLimit       *string           `json:"memLimit,omitempty"` // bytes with optional K/M/G suffix, e.g. "512M"
+	ExitCodes      map[string]string `json:"exitCodes,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	SuccessCodes   []int             `json:"successCodes,omitempty"`
+	Retries        *int              `json:"retries,omitempty"`
+	DependsOn      []string          `json:"dependsOn,omitempty"`
+	Timeout        *string           `json:"timeout,omitempty"` // duration, replaces -timeout
+	OutputEncoding *string           `json:"outputEncoding,omitempty"`
+	Schema         *string           `json:"schema,omitempty"` // JSON Schema file for json output
+	Image          *string           `json:"image,omitempty"`  // container image, see -docker
+	Normalize      *normalizeRules   `json:"normalize,omitempty"`
+	MinSymbols     *int              `json:"minSymbols,omitempty"`
+	SuccessRegex   *string           `json:"successRegex,omitempty"`
+	FailureRegex   *string           `json:"failureRegex,omitempty"`
+	EnvPassthrough []string          `json:"envPassthrough,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+}
+
+// envBool is a config boolean that may instead be a string referencing an
+// environment variable, "${ENABLE_BYBIT}" or "${ENABLE_BYBIT:true}", resolved
+// when the config is loaded.
+type envBool bool
+
+func (b *envBool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = envBool(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("want a boolean or a \"${VAR:default}\" string, got %s", data)
+	}
+	resolved := expandEnvDefault(s)
+	v, err := strconv.ParseBool(resolved)
+	if err != nil {
+		return fmt.Errorf("%q resolves to %q, which is not a boolean", s, resolved)
+	}
+	*b = envBool(v)
+	return nil
+}
+
+// expandEnvDefault expands ${VAR} and ${VAR:default} references; the default
+// applies when VAR is unset or empty.
+func expandEnvDefault(s string) string {
+	return os.Expand(s, func(ref string) string {
+		name, def, _ := strings.Cut(ref, ":")
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return def
+	})
+}
+
+func (c exchangeConfig) apply(ex *Exchange) error {
+	if c.Script != nil {
+		ex.Script = *c.Script
+	}
+	if c.Format != nil {
+		ex.Format = *c.Format
+	}
+	if c.Enabled != nil {
+		ex.Enabled = bool(*c.Enabled)
+	}
+	if c.Note != nil {
+		ex.Note = *c.Note
+	}
+	if c.Output != nil {
+		ex.Output = *c.Output
+	}
+	if c.Priority != nil {
+		ex.Priority = *c.Priority
+	}
+	if c.Host != nil {
+		ex.Host = *c.Host
+	}
+	if c.CPULimit != nil {
+		d, err := time.ParseDuration(*c.CPULimit)
+		if err != nil || d < 0 {
+			return fmt.Errorf("config: %s: cpuLimit %q: want a duration such as \"10m\"", c.Name, *c.CPULimit)
+		}
+		ex.CPULimit = d
+	}
+	if c.Tags != nil {
+		ex.Tags = c.Tags
+	}
+	if c.DependsOn != nil {
+		ex.DependsOn = c.DependsOn
+	}
+	if c.Timeout != nil {
+		d, err := time.ParseDuration(*c.Timeout)
+		if err != nil || d < 0 {
+			return fmt.Errorf("config: %s: timeout %q: want a duration such as \"2m\"", c.Name, *c.Timeout)
+		}
+		ex.Timeout = d
+	}
+	if c.OutputEncoding != nil {
+		if !slices.Contains(outputEncodings, *c.OutputEncoding) {
+			return fmt.Errorf("config: %s: outputEncoding %q: want one of %s", c.Name, *c.OutputEncoding, strings.Join(outputEncodings, ", "))
+		}
+		ex.OutputEncoding = *c.OutputEncoding
+	}
+	if c.Retries != nil {
+		if *c.Retries < 0 {
+			return fmt.Errorf("config: %s: retries must not be negative", c.Name)
+		}
+		ex.Retries = c.Retries
+	}
+	if c.SuccessCodes != nil {
+		for _, code := range c.SuccessCodes {
+			if code < 0 || code > 255 {
+				return fmt.Errorf("config: %s: successCodes: %d is not an exit code", c.Name, code)
+			}
+		}
+		ex.SuccessCodes = c.SuccessCodes
+	}
+	if c.ExitCodes != nil {
+		ex.ExitCodes = map[int]string{}
+		for code, status := range c.ExitCodes {
+			n, err := strconv.Atoi(code)
+			if err != nil || n < 0 || n > 255 {
+				return fmt.Errorf("config: %s: exitCodes: %q is not an exit code", c.Name, code)
+			}
+			if !slices.Contains(exitStatuses, status) {
+				return fmt.Errorf("config: %s: exitCodes: %s: status %q: want one of %s", c.Name, code, status, strings.Join(exitStatuses, ", "))
+			}
+			ex.ExitCodes[n] = status
+		}
+	}
+	if c.MemLimit != nil {
+		n, err := parseSize(*c.MemLimit)
+		if err != nil {
+			return fmt.Errorf("config: %s: memLimit: %w", c.Name, err)
+		}
+		ex.MemLimit = n
+	}
+	if c.MinSymbols != nil {
+		ex.MinSymbols = *c.MinSymbols
+	}
+	if c.EnvPassthrough != nil {
+		for _, pattern := range c.EnvPassthrough {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("config: %s: envPassthrough %q: %w", c.Name, pattern, err)
+			}
+		}
+		ex.EnvPassthrough = c.EnvPassthrough
+	}
+	if c.Env != nil {
+		ex.Env = c.Env
+	}
+	if c.SuccessRegex != nil {
+		re, err := regexp.Compile(*c.SuccessRegex)
+		if err != nil {
+			return fmt.Errorf("config: %s: successRegex: %w", c.Name, err)
+		}
+		ex.SuccessRegex = re
+	}
+	if c.FailureRegex != nil {
+		re, err := regexp.Compile(*c.FailureRegex)
+		if err != nil {
+			return fmt.Errorf("config: %s: failureRegex: %w", c.Name, err)
+		}
+		ex.FailureRegex = re
+	}
+	if c.Schema != nil {
+		ex.Schema = *c.Schema
+	}
+	if c.Image != nil {
+		ex.Image = *c.Image
+	}
+	if c.Normalize != nil {
+		if err := c.Normalize.compile(); err != nil {
+			return fmt.Errorf("config: %s: normalize: %w", c.Name, err)
+		}
+		ex.Normalize = c.Normalize
+	}
+	return nil
+}
+
+// parseSize parses a byte count with an optional binary K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := 0
+	switch {
+	case strings.HasSuffix(num, "K"):
+		shift = 10
+	case strings.HasSuffix(num, "M"):
+		shift = 20
+	case strings.HasSuffix(num, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: want bytes with an optional K, M or G suffix", s)
+	}
+	return n << shift, nil
+}
+
+// limitsEnv carries an exchange's resource limits to the runner
+// re-executed by limitCommand, as "<cpu seconds>:<address space bytes>:<path>".
+const limitsEnv = "RUN_ALL_RLIMITS"
+
+// limitCommand makes cmd apply the exchange's resource limits to the script
+// itself rather than the runner. Go has no hook between fork and exec, so
+// cmd starts the runner's own executable instead, which sets the limits
+// with setrlimit and execs the real command in place (runLimitHelper). The
+// script keeps the pid, process group and arguments it would have had.
+func limitCommand(cmd *exec.Cmd, ex Exchange) error {
+	if (ex.CPULimit <= 0 && ex.MemLimit <= 0) || cmd.Err != nil {
+		return nil // a failed lookup is reported by Run
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("resource limits: %w", err)
+	}
+	// The helper starts in cmd.Dir; a relative path was relative to ours.
+	path, err := filepath.Abs(cmd.Path)
+	if err != nil {
+		return fmt.Errorf("resource limits: %w", err)
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cpu := int64(math.Ceil(ex.CPULimit.Seconds()))
+	cmd.Path = self
+	cmd.Env = append(slices.Clip(env), fmt.Sprintf("%s=%d:%d:%s", limitsEnv, cpu, max(ex.MemLimit, 0), path))
+	return nil
+}
+
+// runLimitHelper is the re-executed side of limitCommand: when limitsEnv
+// is set it applies the limits and execs os.Args, never returning. It must
+// run before anything else in main.
+func runLimitHelper() {
+	spec, ok := os.LookupEnv(limitsEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(limitsEnv)
+	if err := execLimited(spec, os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "run_all: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+func execLimited(spec string, args []string) error {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed %s %q", limitsEnv, spec)
+	}
+	cpu, cpuErr := strconv.ParseUint(parts[0], 10, 64)
+	mem, memErr := strconv.ParseUint(parts[1], 10, 64)
+	if err := errors.Join(cpuErr, memErr); err != nil {
+		return fmt.Errorf("malformed %s %q: %w", limitsEnv, spec, err)
+	}
+	if cpu > 0 {
+		// SIGXCPU at the soft limit, SIGKILL a second later at the hard
+		// one for a script that handles or ignores it.
+		if err := lowerLimit(syscall.RLIMIT_CPU, cpu, cpu+1); err != nil {
+			return fmt.Errorf("cpu limit: %w", err)
+		}
+	}
+	if mem > 0 {
+		if err := lowerLimit(syscall.RLIMIT_AS, mem, mem); err != nil {
+			return fmt.Errorf("memory limit: %w", err)
+		}
+	}
+	return syscall.Exec(parts[2], args, os.Environ())
+}
+
+// lowerLimit sets a resource limit, keeping a hard limit that is already
+// lower, since raising one takes privileges.
+func lowerLimit(resource int, soft, hard uint64) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &lim); err != nil {
+		return err
+	}
+	lim.Max = min(lim.Max, hard)
+	lim.Cur = min(soft, lim.Max)
+	return syscall.Setrlimit(resource, &lim)
+}
+
+// containerScriptDir is where -docker mounts the script directory.
+const containerScriptDir = "/scripts"
+
+// dockerRunFailed is the exit status of docker run itself failing, e.g. for
+// an unknown image, as opposed to the script's own exit status.
+const dockerRunFailed = 125
+
+// hostOnlyEnv describe the host, or point at host files, and are not
+// passed into containers.
+var hostOnlyEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "PWD", "OLDPWD", "TMPDIR", "HOSTNAME", "TERM", "VIRTUAL_ENV", "PYTHONHOME", "PYTHONPATH", "SSL_CERT_FILE", "SSL_CERT_DIR", "REQUESTS_CA_BUNDLE"}
+
+// containerSeq keeps container names unique within the runner.
+var containerSeq atomic.Int64
+
+// dockerCommand runs the script in image instead of on the host (-docker,
+// config image), with the script directory mounted at containerScriptDir.
+// Of the script's environment only what the runner or the exchange set,
+// essentialEnv and envPassthrough matches are forwarded, minus
+// hostOnlyEnv, and by name so values stay out of the process list. docker
+// run proxies SIGTERM to the container and exits with the script's status.
+// The container's name is returned for removal if the client is killed.
+func dockerCommand(image, scriptDir string, ex Exchange, opts runOptions, env []string) (string, []string, string, error) {
+	abs, err := filepath.Abs(scriptDir)
+	if err != nil {
+		return "", nil, "", err
+	}
+	container := fmt.Sprintf("run_all-%s-%d-%d", ex.Name, os.Getpid(), containerSeq.Add(1))
+	args := []string{"run", "--rm", "--init", "--name", container,
+		"-v", abs + ":" + containerScriptDir,
+		"-w", path.Join(containerScriptDir, path.Dir(filepath.ToSlash(ex.Script)))}
+	if opts.InteractiveStdin {
+		args = append(args, "-i")
+	}
+	if opts.CPUSet != "" {
+		args = append(args, "--cpuset-cpus", opts.CPUSet)
+	}
+	// The same rlimits limitCommand sets on the host, applied by docker.
+	if ex.CPULimit > 0 {
+		cpu := int64(math.Ceil(ex.CPULimit.Seconds()))
+		args = append(args, "--ulimit", fmt.Sprintf("cpu=%d:%d", cpu, cpu+1))
+	}
+	if ex.MemLimit > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("as=%d:%d", ex.MemLimit, ex.MemLimit))
+	}
+	inherited := os.Environ()
+	kept := filterEnv(env, append(slices.Clone(essentialEnv), ex.EnvPassthrough...))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		switch {
+		case slices.Contains(hostOnlyEnv, key):
+		case slices.Contains(inherited, kv) && !slices.Contains(kept, kv):
+			// Inherited host environment stays on the host.
+		case key == "OUTPUT_DIR":
+			// The staging directory lives under the script directory.
+			rel, err := filepath.Rel(abs, value)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return "", nil, "", fmt.Errorf("-docker: OUTPUT_DIR %s is outside the mounted %s", value, abs)
+			}
+			args = append(args, "-e", "OUTPUT_DIR="+path.Join(containerScriptDir, filepath.ToSlash(rel)))
+		default:
+			args = append(args, "-e", key)
+		}
+	}
+	// A host interpreter path means nothing inside the image.
+	interpreter := opts.Interpreter
+	if filepath.IsAbs(interpreter) {
+		interpreter = defaultInterpreter
+	}
+	args = append(args, image, interpreter, path.Join(containerScriptDir, filepath.ToSlash(ex.Script)))
+	return "docker", args, container, nil
+}
+
+// cpuListPattern matches taskset CPU lists such as "0-3" or "0,2,4-7".
+var cpuListPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// checkCPUSet reports whether scripts can be pinned to cpus here. There is
+// no affinity field in SysProcAttr, so pinning goes through taskset, which
+// only Linux has; a trial run also rejects CPUs the host doesn't have.
+func checkCPUSet(cpus string) error {
+	taskset, err := exec.LookPath("taskset")
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command(taskset, "-c", cpus, "true").CombinedOutput(); err != nil {
+		return fmt.Errorf("taskset -c %s: %s", cpus, cmp.Or(strings.TrimSpace(string(out)), err.Error()))
+	}
+	return nil
+}
+
+// pinnedCommand wraps name and args in taskset when opts.CPUSet is set.
+// Children the script spawns inherit the affinity.
+func pinnedCommand(opts runOptions, name string, args []string) (string, []string) {
+	if opts.CPUSet == "" {
+		return name, args
+	}
+	return "taskset", append([]string{"-c", opts.CPUSet, name}, args...)
+}
+
+// exitStatus looks up the exchange's ExitCodes entry for a script's exit
+// code, as exitCodeOf returns it, then successCodes, returning "" when the
+// code is unmapped or the script didn't exit normally.
+func exitStatus(ex Exchange, code int, successCodes []int) string {
+	if code < 0 {
+		return ""
+	}
+	if status, ok := ex.ExitCodes[code]; ok {
+		return status
+	}
+	if code != 0 && slices.Contains(successCodes, code) {
+		return "success"
+	}
+	return ""
+}
+
+// parseExitCodes parses a comma-separated list of exit codes.
+func parseExitCodes(s string) ([]int, error) {
+	codes := []int{}
+	for _, field := range splitList(s) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || n > 255 {
+			return nil, fmt.Errorf("%q is not an exit code", field)
+		}
+		codes = append(codes, n)
+	}
+	return codes, nil
+}
+
+// recording is one script run saved by -record and played back by -replay.
+type recording struct {
+	Name     string        `json:"name"`
+	ExitCode int           `json:"exit_code"`       // -1 when the script didn't exit on its own
+	Error    string        `json:"error,omitempty"` // set with ExitCode -1
+	Duration time.Duration `json:"duration_ns"`
+	Symbols  int           `json:"symbols"`
+	Checksum string        `json:"checksum,omitempty"`
+	Chunks   []chunk       `json:"chunks"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// chunk is one write to stdout or stderr, At after the script started.
+type chunk struct {
+	At     time.Duration `json:"at_ns"`
+	Stream string        `json:"stream"`
+	Data   string        `json:"data"`
+}
+
+func (r *recording) stream(name string) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.Chunks = append(r.Chunks, chunk{At: time.Since(r.start), Stream: name, Data: string(p)})
+		return len(p), nil
+	})
+}
+
+func (r *recording) setResult(err error) {
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		r.ExitCode = exitErr.ExitCode()
+	default:
+		r.ExitCode, r.Error = -1, err.Error()
+	}
+}
+
+func recordingPath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+func (r *recording) save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(recordingPath(dir, r.Name), data)
+}
+
+func loadRecording(dir, name string) (*recording, error) {
+	data, err := os.ReadFile(recordingPath(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("no recording: %w", err)
+	}
+	r := &recording{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("parse recording %s: %w", recordingPath(dir, name), err)
+	}
+	return r, nil
+}
+
+// play writes the recorded output in its original order and returns the
+// recorded outcome. With timing, it waits as long as the script did.
+func (r *recording) play(ctx context.Context, stdout, stderr io.Writer, timing bool) error {
+	start := time.Now()
+	wait := func(at time.Duration) error {
+		if !timing {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(at - time.Since(start)):
+			return nil
+		}
+	}
+	for _, c := range r.Chunks {
+		if err := wait(c.At); err != nil {
+			return err
+		}
+		w := stdout
+		if c.Stream == "stderr" {
+			w = stderr
+		}
+		io.WriteString(w, c.Data)
+	}
+	if err := wait(r.Duration); err != nil {
+		return err
+	}
+	switch {
+	case r.ExitCode > 0:
+		return replayedExit(r.ExitCode)
+	case r.ExitCode < 0:
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// replayedExit stands in for the *exec.ExitError of a recorded run.
+type replayedExit int
+
+func (e replayedExit) Error() string { return "exit status " + strconv.Itoa(int(e)) }
+func (e replayedExit) ExitCode() int { return int(e) }
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// allocationFailure matches what common runtimes print when an allocation
+// is refused, as happens once the address-space limit is reached.
+var allocationFailure = regexp.MustCompile(`MemoryError|Cannot allocate memory|[Oo]ut of memory|std::bad_alloc|failed to allocate|ENOMEM`)
+
+// limitExceeded explains a failure caused by one of the exchange's resource
+// limits, or returns "". The CPU limit is told by its signals: SIGXCPU, or
+// the SIGKILL at the hard limit after the script used up its CPU time. An
+// exhausted address space only makes allocations fail, so the memory limit
+// is told by an allocation error on stderr, or by an abort or segfault,
+// which is how unchecked allocation failures usually end.
+func limitExceeded(ex Exchange, err error, stderr string) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	status, _ := exitErr.Sys().(syscall.WaitStatus)
+	signal := syscall.Signal(-1)
+	if status.Signaled() {
+		signal = status.Signal()
+	}
+	cpu := exitErr.UserTime() + exitErr.SystemTime()
+	switch {
+	case ex.CPULimit > 0 && (signal == syscall.SIGXCPU || signal == syscall.SIGKILL && cpu >= ex.CPULimit):
+		return fmt.Sprintf("cpu limit of %v exceeded", ex.CPULimit)
+	case ex.MemLimit > 0 && (allocationFailure.MatchString(stderr) || signal == syscall.SIGABRT || signal == syscall.SIGSEGV):
+		return fmt.Sprintf("memory limit of %d MiB exceeded", ex.MemLimit>>20)
+	}
+	return ""
+}
+
+// annotationPrefix starts an inline settings comment near the top of a
+// script, e.g. "# runner: timeout=120s retries=2 format=remove_dash".
+const annotationPrefix = "runner:"
+
+// annotationLines is how far into a script annotations are looked for.
+const annotationLines = 40
+
+// readAnnotations parses a script's "# runner:" comments into the config
+// entry they amount to. found is false when the script has none.
+func readAnnotations(path, name string) (c exchangeConfig, found bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return c, false, err
+	}
+	defer f.Close()
+	c.Name = name
+	scanner := bufio.NewScanner(f)
+	for n := 0; n < annotationLines && scanner.Scan(); n++ {
+		comment, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "#")
+		if !ok {
+			continue
+		}
+		rest, ok := strings.CutPrefix(strings.TrimSpace(comment), annotationPrefix)
+		if !ok {
+			continue
+		}
+		found = true
+		for _, field := range strings.Fields(rest) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return c, true, fmt.Errorf("%s: annotation %q is not key=value", path, field)
+			}
+			switch key {
+			case "timeout":
+				c.Timeout = &value
+			case "format":
+				c.Format = &value
+			case "host":
+				c.Host = &value
+			case "retries", "priority", "minSymbols":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return c, true, fmt.Errorf("%s: annotation %s=%q: want an integer", path, key, value)
+				}
+				switch key {
+				case "retries":
+					c.Retries = &n
+				case "priority":
+					c.Priority = &n
+				default:
+					c.MinSymbols = &n
+				}
+			case "enabled":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return c, true, fmt.Errorf("%s: annotation enabled=%q: want true or false", path, value)
+				}
+				c.Enabled = (*envBool)(&b)
+			default:
+				return c, true, fmt.Errorf("%s: unknown annotation %q", path, key)
+			}
+		}
+	}
+	return c, found, scanner.Err()
+}
+
+// applyAnnotations overlays each script's inline annotations onto the
+// roster. Invalid annotations are logged and that script's are ignored.
+func applyAnnotations(scriptDir string, exchanges []Exchange) []Exchange {
+	exchanges = slices.Clone(exchanges)
+	for i, ex := range exchanges {
+		c, found, err := readAnnotations(filepath.Join(scriptDir, ex.Script), ex.Name)
+		if os.IsNotExist(err) || (!found && err == nil) {
+			continue
+		}
+		if err == nil {
+			err = c.apply(&exchanges[i])
+		}
+		if err != nil {
+			slog.Warn("ignoring runner annotations", "exchange", ex.Name, "error", err)
+			exchanges[i] = ex
+			continue
+		}
+		slog.Debug("applied runner annotations", "exchange", ex.Name)
+	}
+	return exchanges
+}
+
+func loadConfig(path string) (*config, error) {
+	top, err := readConfig(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := mapToStruct(top, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// readConfig reads a config file as its top-level keys, with its extends
+// chain applied: keys set here replace the base's, except exchanges, which
+// merge by name, field by field, with new names appended. chain holds the
+// files already being read, to catch cycles.
+func readConfig(path string, chain []string) (map[string]json.RawMessage, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(chain, abs) {
+		return nil, fmt.Errorf("config: circular extends: %s", strings.Join(append(chain, abs), " -> "))
+	}
+	chain = append(chain, abs)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	raw, ok := top["extends"]
+	if !ok {
+		return top, nil
+	}
+	delete(top, "extends")
+	var basePath string
+	if err := json.Unmarshal(raw, &basePath); err != nil || basePath == "" {
+		return nil, fmt.Errorf("config %s: extends must be a file name", path)
+	}
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(path), basePath)
+	}
+	base, err := readConfig(basePath, chain)
+	if err != nil {
+		return nil, err
+	}
+	for key, v := range top {
+		if key != "exchanges" || base[key] == nil {
+			base[key] = v
+			continue
+		}
+		merged, err := mergeExchangeEntries(base[key], v)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: exchanges: %w", path, err)
+		}
+		base[key] = merged
+	}
+	return base, nil
+}
+
+// mergeExchangeEntries overlays one exchanges list on another, by name.
+func mergeExchangeEntries(base, overlay json.RawMessage) (json.RawMessage, error) {
+	var baseEntries, overlayEntries []map[string]json.RawMessage
+	if err := json.Unmarshal(base, &baseEntries); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(overlay, &overlayEntries); err != nil {
+		return nil, err
+	}
+	name := func(entry map[string]json.RawMessage) string {
+		var s string
+		json.Unmarshal(entry["name"], &s)
+		return s
+	}
+	for _, entry := range overlayEntries {
+		i := slices.IndexFunc(baseEntries, func(b map[string]json.RawMessage) bool { return name(b) != "" && name(b) == name(entry) })
+		if i < 0 {
+			baseEntries = append(baseEntries, entry)
+			continue
+		}
+		maps.Copy(baseEntries[i], entry)
+	}
+	return json.Marshal(baseEntries)
+}
+
+// symbolFormats are the symbol naming conventions an exchange's Format may
+// name; empty means the exchange isn't on TradingView.
+var symbolFormats = []string{"keep_original", "remove_dash"}
+
+// jsonFields returns the JSON keys of a struct type's fields.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for f := range t.Fields() {
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unknownKeys lists the keys of a JSON object that t has no field for.
+func unknownKeys(raw map[string]json.RawMessage, t reflect.Type, where string) []string {
+	known := jsonFields(t)
+	var problems []string
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		if !slices.Contains(known, key) {
+			problems = append(problems, fmt.Sprintf("%s: unknown field %q", where, key))
+		}
+	}
+	return problems
+}
+
+// validateConfig checks a -config file without running anything and
+// returns every problem found rather than stopping at the first.
+func validateConfig(path, scriptDir string) []string {
+	top, err := readConfig(path, nil)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems := unknownKeys(top, reflect.TypeFor[config](), "config")
+	var entries []map[string]json.RawMessage
+	if v, ok := top["exchanges"]; ok {
+		if err := json.Unmarshal(v, &entries); err != nil {
+			return append(problems, fmt.Sprintf("exchanges: %v", err))
+		}
+	}
+
+	for _, key := range []string{"proxy", "noProxy"} {
+		var s string
+		if v, ok := top[key]; ok && json.Unmarshal(v, &s) != nil {
+			problems = append(problems, fmt.Sprintf("config: %s must be a string", key))
+		}
+	}
+	if v, ok := top["requiredExchanges"]; ok {
+		var names []string
+		if json.Unmarshal(v, &names) != nil {
+			problems = append(problems, "config: requiredExchanges must be a list of strings")
+		}
+	}
+	if v, ok := top["normalize"]; ok {
+		var n normalizeRules
+		if err := json.Unmarshal(v, &n); err != nil {
+			problems = append(problems, fmt.Sprintf("config: normalize: %v", err))
+		} else if err := n.compile(); err != nil {
+			problems = append(problems, "config: normalize: "+err.Error())
+		}
+	}
+	if v, ok := top["maskPatterns"]; ok {
+		var patterns []string
+		if err := json.Unmarshal(v, &patterns); err != nil {
+			problems = append(problems, "config: maskPatterns must be a list of strings")
+		} else if _, err := compileMaskPatterns(patterns); err != nil {
+			problems = append(problems, "config: "+err.Error())
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		where := fmt.Sprintf("exchanges[%d]", i)
+		var c exchangeConfig
+		if err := mapToStruct(entry, &c); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+			continue
+		}
+		if c.Name == "" {
+			problems = append(problems, where+": missing name")
+			continue
+		}
+		where += " (" + c.Name + ")"
+		problems = append(problems, unknownKeys(entry, reflect.TypeFor[exchangeConfig](), where)...)
+		if seen[c.Name] {
+			problems = append(problems, where+": duplicate name")
+		}
+		seen[c.Name] = true
+		if c.Format != nil && *c.Format != "" && !slices.Contains(symbolFormats, *c.Format) {
+			problems = append(problems, fmt.Sprintf("%s: format %q: want one of %s", where, *c.Format, strings.Join(symbolFormats, ", ")))
+		}
+
+		ex := Exchange{Name: c.Name, Script: c.Name + ".py"}
+		if i := slices.IndexFunc(defaultExchanges, func(d Exchange) bool { return d.Name == c.Name }); i >= 0 {
+			ex = defaultExchanges[i]
+		}
+		if err := c.apply(&ex); err != nil {
+			problems = append(problems, where+": "+strings.TrimPrefix(err.Error(), "config: "+c.Name+": "))
+		}
+		if _, err := os.Stat(filepath.Join(scriptDir, ex.Script)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: script %s not found in %s", where, ex.Script, scriptDir))
+		}
+		if ex.Schema != "" {
+			if _, err := loadSchema(filepath.Join(scriptDir, ex.Schema)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: schema: %v", where, err))
+			}
+		}
+	}
+	return problems
+}
+
+// mapToStruct decodes an already split JSON object into v.
+func mapToStruct(m map[string]json.RawMessage, v any) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func mergeExchanges(base []Exchange, cfg *config) ([]Exchange, error) {
+	exchanges := slices.Clone(base)
+	for _, c := range cfg.Exchanges {
+		if c.Name == "" {
+			return nil, errors.New("config: exchange entry without a name")
+		}
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Name == c.Name })
+		if i < 0 {
+			exchanges = append(exchanges, Exchange{Name: c.Name, Script: c.Name + ".py", Enabled: true})
+			i = len(exchanges) - 1
+		}
+		if err := c.apply(&exchanges[i]); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Normalize != nil {
+		if err := cfg.Normalize.compile(); err != nil {
+			return nil, fmt.Errorf("config: normalize: %w", err)
+		}
+		for i := range exchanges {
+			if exchanges[i].Normalize == nil {
+				exchanges[i].Normalize = cfg.Normalize
+			}
+		}
+	}
+	return exchanges, nil
+}
+
+func (ex Exchange) OutputDir() string {
+	if ex.Output != "" {
+		return ex.Output
+	}
+	return "data_" + ex.Name + "_1d"
+}
+
+// manifest lists the expected SHA-256 of each script (-manifest), keyed by
+// script path relative to the script directory.
+type manifest struct {
+	Scripts map[string]string `json:"scripts"`
+}
+
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// check compares every manifest entry and every script about to run with
+// the files on disk and describes each mismatch.
+func (m *manifest) check(scriptDir string, exchanges []Exchange) []string {
+	var problems []string
+	for _, script := range slices.Sorted(maps.Keys(m.Scripts)) {
+		sum, err := fileChecksum(filepath.Join(scriptDir, script))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, script+": missing")
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", script, err))
+		case !strings.EqualFold(sum, m.Scripts[script]):
+			problems = append(problems, fmt.Sprintf("%s: checksum %.12s, manifest expects %.12s", script, sum, m.Scripts[script]))
+		}
+	}
+	for _, ex := range exchanges {
+		if _, ok := m.Scripts[ex.Script]; !ok {
+			problems = append(problems, ex.Script+": not in manifest")
+		}
+	}
+	return problems
+}
+
+// fetchTimeout bounds cloning or downloading -fetch-scripts.
+const fetchTimeout = 5 * time.Minute
+
+// fetchScripts makes the scripts at source available locally and returns
+// their directory (-fetch-scripts). source is a git URL, or a .tar.gz,
+// .tgz or .zip archive given as a URL or local path. Fetched trees are kept
+// under the user cache directory: git checkouts keyed by commit, archives
+// by URL and revalidated with the server's ETag, so an unchanged source is
+// not downloaded again.
+func fetchScripts(ctx context.Context, source string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "run_all", "scripts")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	if !isArchive(source) {
+		return fetchGit(ctx, source, cacheDir)
+	}
+	return fetchArchive(ctx, source, cacheDir)
+}
+
+func isArchive(source string) bool {
+	u, err := url.Parse(source)
+	if err == nil && u.Scheme != "" {
+		source = u.Path
+	}
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(source, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func fetchGit(ctx context.Context, source, cacheDir string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "ls-remote", source, "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote %s: %w", source, err)
+	}
+	commit, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	if commit == "" {
+		return "", fmt.Errorf("git ls-remote %s: no HEAD", source)
+	}
+	dir := filepath.Join(cacheDir, "git-"+commit)
+	if fileExists(dir) {
+		slog.Info("using cached scripts", "source", source, "commit", commit, "dir", dir)
+		return dir, nil
+	}
+	tmp, err := os.MkdirTemp(cacheDir, ".clone-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	clone := exec.CommandContext(ctx, "git", "clone", "--quiet", "--depth", "1", source, tmp)
+	clone.Stderr = os.Stderr
+	if err := clone.Run(); err != nil {
+		return "", fmt.Errorf("git clone %s: %w", source, err)
+	}
+	// HEAD may have moved since ls-remote; key by what was cloned.
+	if out, err := exec.CommandContext(ctx, "git", "-C", tmp, "rev-parse", "HEAD").Output(); err == nil {
+		commit = strings.TrimSpace(string(out))
+		dir = filepath.Join(cacheDir, "git-"+commit)
+	}
+	if err := os.Rename(tmp, dir); err != nil && !fileExists(dir) {
+		return "", err
+	}
+	slog.Info("fetched scripts", "source", source, "commit", commit, "dir", dir)
+	return dir, nil
+}
+
+// archiveCache records what a cached archive download was, for the next
+// run's conditional request.
+type archiveCache struct {
+	ETag string `json:"etag"`
+	Dir  string `json:"dir"`
+}
+
+func fetchArchive(ctx context.Context, source, cacheDir string) (string, error) {
+	sum := sha256.Sum256([]byte(source))
+	key := hex.EncodeToString(sum[:8])
+	metaPath := filepath.Join(cacheDir, "archive-"+key+".json")
+	var cached archiveCache
+	if data, err := os.ReadFile(metaPath); err == nil {
+		json.Unmarshal(data, &cached)
+	}
+
+	var body io.Reader
+	var etag string
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return "", err
+		}
+		if cached.ETag != "" && fileExists(cached.Dir) {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotModified {
+			slog.Info("using cached scripts", "source", source, "dir", cached.Dir)
+			return cached.Dir, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetch %s: %s", source, resp.Status)
+		}
+		body, etag = resp.Body, resp.Header.Get("ETag")
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		body = f
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", source, err)
+	}
+	contentSum := sha256.Sum256(data)
+	dir := filepath.Join(cacheDir, "archive-"+hex.EncodeToString(contentSum[:]))
+	if !fileExists(dir) {
+		tmp, err := os.MkdirTemp(cacheDir, ".extract-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		if bytes.HasPrefix(data, []byte("PK\x03\x04")) { // zip magic
+			err = extractZip(data, tmp)
+		} else {
+			err = extractTarGz(data, tmp)
+		}
+		if err != nil {
+			return "", fmt.Errorf("extract %s: %w", source, err)
+		}
+		if err := os.Rename(tmp, dir); err != nil && !fileExists(dir) {
+			return "", err
+		}
+	}
+	// Release archives usually wrap everything in one top-level directory.
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		dir = filepath.Join(dir, entries[0].Name())
+	}
+	if meta, err := json.Marshal(archiveCache{ETag: etag, Dir: dir}); err == nil {
+		writeFileAtomic(metaPath, meta)
+	}
+
+	slog.Info("fetched scripts", "source", source, "dir", dir)
+	return dir, nil
+}
+
+// extractTarGz unpacks regular files and directories into dir. Going
+// through os.Root rejects entries that would land outside it.
+func extractTarGz(data []byte, dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = root.MkdirAll(name, 0o755)
+		case tar.TypeReg:
+			err = extractFile(root, name, hdr.FileInfo().Mode(), tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(data []byte, dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() {
+			if err := root.MkdirAll(name, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(root, name, f.Mode(), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(root *os.Root, name string, mode os.FileMode, r io.Reader) error {
+	if err := root.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// outputChecksum hashes every file in dir, in name order, so that two runs
+// producing byte-identical output yield the same digest.
+func outputChecksum(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", entry.Name())
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// outputFormats are the values accepted by -output-format. Scripts learn the
+// selected one from OUTPUT_FORMAT and name their data files accordingly.
+var outputFormats = []string{"csv", "json", "txt"}
+
+// outputEncodings are the values an exchange's outputEncoding may take.
+// Whatever was requested, the runner reads both plain and .gz data files.
+var outputEncodings = []string{"plain", "gzip"}
+
+// stagingDir is where -atomic-output has a script write instead of dir.
+func stagingDir(dir string) string {
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".staging")
+}
+
+// publishDir replaces dest with staging. Readers may briefly find dest
+// missing, but never see it partially written.
+func publishDir(staging, dest string) error {
+	old := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".old")
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dest, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		os.Rename(old, dest)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// symbolFiles lists the per-symbol data files in dir for the given output
+// format, gzipped (.gz) or not. Bookkeeping files such as
+// _failed_symbols_1d.txt are ignored.
+func symbolFiles(dir, format string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, "_") || !strings.HasSuffix(strings.TrimSuffix(name, ".gz"), "."+format) {
+			continue
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// validateOutput checks the data files in dir beyond their mere presence:
+// with the json format every file must hold valid JSON and, when
+// schemaFile is set, match that JSON Schema.
+func validateOutput(dir, format, schemaFile string) error {
+	if format != "json" {
+		return nil
+	}
+	var schema *jsonSchema
+	if schemaFile != "" {
+		var err error
+		if schema, err = loadSchema(schemaFile); err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+	}
+	files, err := symbolFiles(dir, format)
+	if err != nil {
+		return nil // nothing written; the symbol count reports that
+	}
+	var invalid, mismatches []string
+	for _, name := range files {
+		data, err := readDataFile(filepath.Join(dir, name))
+		if err != nil || !json.Valid(data) {
+			invalid = append(invalid, name)
+			continue
+		}
+		if schema != nil {
+			var v any
+			json.Unmarshal(data, &v)
+			for _, problem := range schema.validate(v, "") {
+				mismatches = append(mismatches, name+": "+problem)
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%d output file(s) are not valid JSON, e.g. %s", len(invalid), invalid[0])
+	}
+	if len(mismatches) > 0 {
+		shown := mismatches[:min(len(mismatches), maxSchemaErrors)]
+		msg := strings.Join(shown, "; ")
+		if more := len(mismatches) - len(shown); more > 0 {
+			msg += fmt.Sprintf("; and %d more", more)
+		}
+		return fmt.Errorf("output does not match schema %s: %s", filepath.Base(schemaFile), msg)
+	}
+	return nil
+}
+
+// schemaPath resolves an exchange's schema against the script directory.
+func schemaPath(scriptDir string, ex Exchange) string {
+	if ex.Schema == "" || filepath.IsAbs(ex.Schema) {
+		return ex.Schema
+	}
+	return filepath.Join(scriptDir, ex.Schema)
+}
+
+// maxSchemaErrors is how many schema violations a failure message lists.
+const maxSchemaErrors = 5
+
+// jsonSchema is the subset of JSON Schema that output validation
+// understands: type, enum, required, properties, additionalProperties
+// (boolean), items, pattern, minLength/maxLength, minimum/maximum and
+// minItems/maxItems. loadSchema rejects any other keyword, except
+// annotations such as title and description.
+type jsonSchema struct {
+	Type                 json.RawMessage        `json:"type"` // a type name or a list of them
+	Enum                 []any                  `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Pattern              string                 `json:"pattern"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	types   []string
+	pattern *regexp.Regexp
+}
+
+func loadSchema(path string) (*jsonSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := checkSchemaKeywords(raw, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s := &jsonSchema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// schemaKeywords are the keywords jsonSchema implements; schemaAnnotations
+// are accepted and ignored since they never affect validation.
+var (
+	schemaKeywords    = []string{"type", "enum", "required", "properties", "additionalProperties", "items", "pattern", "minLength", "maxLength", "minimum", "maximum", "minItems", "maxItems"}
+	schemaAnnotations = []string{"$schema", "$id", "$comment", "title", "description", "default", "examples"}
+)
+
+// checkSchemaKeywords rejects any keyword outside the implemented subset,
+// such as $ref or oneOf, throughout a schema: ignoring it would let every
+// document pass the check it was meant to make.
+func checkSchemaKeywords(raw json.RawMessage, at string) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return fmt.Errorf("%s: want a schema object", cmp.Or(at, "schema"))
+	}
+	prefix := ""
+	if at != "" {
+		prefix = at + "."
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if !slices.Contains(schemaKeywords, key) && !slices.Contains(schemaAnnotations, key) {
+			return fmt.Errorf("%s%s: unsupported keyword; only %s are implemented", prefix, key, strings.Join(schemaKeywords, ", "))
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		var b bool
+		if json.Unmarshal(v, &b) != nil {
+			return fmt.Errorf("%sadditionalProperties: only true or false is supported", prefix)
+		}
+	}
+	if v, ok := m["properties"]; ok {
+		var props map[string]json.RawMessage
+		if err := json.Unmarshal(v, &props); err != nil {
+			return fmt.Errorf("%sproperties: want an object", prefix)
+		}
+		for _, name := range slices.Sorted(maps.Keys(props)) {
+			if err := checkSchemaKeywords(props[name], prefix+"properties."+name); err != nil {
+				return err
+			}
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if err := checkSchemaKeywords(v, prefix+"items"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pointerEscaper escapes a property name as a JSON Pointer token.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// compile resolves type lists and patterns throughout the schema.
+func (s *jsonSchema) compile() error {
+	if len(s.Type) > 0 {
+		if err := json.Unmarshal(s.Type, &s.types); err != nil {
+			var one string
+			if err := json.Unmarshal(s.Type, &one); err != nil {
+				return fmt.Errorf("type: want a string or a list of strings, got %s", s.Type)
+			}
+			s.types = []string{one}
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(); err != nil {
+			return fmt.Errorf("properties.%s: %w", name, err)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+	}
+	return nil
+}
+
+// validate returns a description of every violation in v, each prefixed
+// with its JSON Pointer below at.
+func (s *jsonSchema) validate(v any, at string) []string {
+	where := cmp.Or(at, "/")
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, where+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return jsonTypeIs(v, t) }) {
+		fail("want %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(v))
+		return problems
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		fail("%v is not one of the allowed values", v)
+	}
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("%q is shorter than %d", v, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("%q is longer than %d", v, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("%q does not match %s", v, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("%v is below the minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("%v is above the maximum %v", v, *s.Maximum)
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("%d items, want at least %d", len(v), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("%d items, want at most %d", len(v), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, s.Items.validate(item, at+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			prop, ok := s.Properties[name]
+			switch {
+			case ok:
+				problems = append(problems, prop.validate(v[name], at+"/"+pointerEscaper.Replace(name))...)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				fail("unexpected property %q", name)
+			}
+		}
+	}
+	return problems
+}
+
+func jsonTypeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func jsonTypeIs(v any, t string) bool {
+	got := jsonTypeOf(v)
+	return got == t || (t == "number" && got == "integer")
+}
+
+// readDataFile returns a data file's contents, decompressing .gz files.
+func readDataFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(name, ".gz") {
+		return io.ReadAll(f)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// readSymbols lists the symbols an exchange has written to dir, one per
+// "<SYMBOL>_<timeframe>.<format>[.gz]" file.
+func readSymbols(dir, format string) ([]string, error) {
+	files, err := symbolFiles(dir, format)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]string, 0, len(files))
+	for _, name := range files {
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), "."+format)
+		if i := strings.LastIndex(name, "_"); i > 0 {
+			name = name[:i]
+		}
+		symbols = append(symbols, name)
+	}
+	return symbols, nil
+}
+
+const defaultInterpreter = "python3"
+
+// console receives all human-facing progress output and the scripts' own
+// output. It is stdout unless stdout is reserved for machine-readable events
+// (-stream).
+var console io.Writer = os.Stdout
+
+// scriptStderr receives the scripts' stderr as it is produced.
+var scriptStderr io.Writer = os.Stderr
+
+// bufferedOutput batches writes to console or scriptStderr (-output-buffer).
+// Parallel scripts write to it concurrently, hence the lock.
+type bufferedOutput struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// outputBuffers are flushed by flushOutput.
+var outputBuffers []*bufferedOutput
+
+func newBufferedOutput(w io.Writer, size int) *bufferedOutput {
+	b := &bufferedOutput{w: bufio.NewWriterSize(w, size)}
+	outputBuffers = append(outputBuffers, b)
+	return b
+}
+
+func (b *bufferedOutput) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Write(p)
+}
+
+// flushOutput writes out whatever -output-buffer holds: after each
+// script, and before the runner exits.
+func flushOutput() {
+	for _, b := range outputBuffers {
+		b.mu.Lock()
+		b.w.Flush()
+		b.mu.Unlock()
+	}
+}
+
+// sessionLog mirrors console and scriptStderr to the -tee file, optionally
+// stamping each line with the time it started. Writing to it never fails,
+// so the terminal keeps working if the disk fills up; the first error is
+// logged.
+type sessionLog struct {
+	mu         sync.Mutex
+	f          *os.File
+	timestamps bool
+	midLine    bool
+	failed     bool
+}
+
+func (s *sessionLog) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := p
+	if s.timestamps {
+		var buf bytes.Buffer
+		for line := range bytes.Lines(p) {
+			if !s.midLine {
+				buf.WriteString(time.Now().Format("2006-01-02 15:04:05.000 "))
+			}
+			buf.Write(line)
+			s.midLine = line[len(line)-1] != '\n'
+		}
+		out = buf.Bytes()
+	}
+	if _, err := s.f.Write(out); err != nil && !s.failed {
+		s.failed = true
+		slog.Error("failed to write -tee file", "path", s.f.Name(), "error", err)
+	}
+	return len(p), nil
+}
+
+// Working exchanges (17 total) - verified with TradingView
+var defaultExchanges = []Exchange{
+	{Name: "bitmart", Script: "bitmart.py", Format: "keep_original", Note: "VERIFIED: BITMART exchange"},
+	{Name: "bitrue", Script: "bitrue.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: BITRUE exchange"},
+	{Name: "btse", Script: "btse.py", Format: "remove_dash", Enabled: true, Note: "VERIFIED: BTSE exchange"},
+	{Name: "bybit", Script: "bybit.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: BYBIT exchange"},
+	{Name: "coinbase", Script: "coinbase.py", Format: "remove_dash", Note: "VERIFIED: COINBASE exchange"},
+	{Name: "coinex", Script: "coinex.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: COINEX exchange"},
+	{Name: "coinw", Script: "coinw.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: COINW exchange"},
+	{Name: "cryptocom", Script: "cryptocom.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: CRYPTOCOM exchange"},
+	{Name: "gateio", Script: "gateio.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: GATEIO exchange"},
+	{Name: "gemini", Script: "gemini.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: GEMINI exchange"},
+	{Name: "htx", Script: "htx.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: HTX exchange"},
+	{Name: "kraken", Script: "kraken.py", Format: "keep_original", Note: "VERIFIED: KRAKEN exchange"},
+	{Name: "kucoin", Script: "kucoin.py", Format: "remove_dash", Enabled: true, Note: "VERIFIED: KUCOIN exchange"},
+	{Name: "mexc", Script: "mexc.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: MEXC exchange"},
+	{Name: "okx", Script: "okx.py", Format: "remove_dash", Note: "VERIFIED: OKX exchange"},
+	{Name: "whitebit", Script: "whitebit.py", Format: "keep_original", Enabled: true, Note: "VERIFIED: WHITEBIT exchange"},
+
+	// SKIPPED: Not available on TradingView (8 exchanges)
+	{Name: "biconomy", Script: "biconomy.py", Note: "Not available on TradingView"},
+	{Name: "bigone", Script: "bigone.py", Note: "Not available on TradingView"},
+	{Name: "deepcoin", Script: "deepcoin.py", Note: "Not available on TradingView"},
+	{Name: "digifinex", Script: "digifinex.py", Note: "Not available on TradingView"},
+	{Name: "hashkeyglobal", Script: "hashkeyglobal.py", Note: "Not available on TradingView"},
+	{Name: "lbank", Script: "lbank.py", Note: "Not available on TradingView"},
+	{Name: "pionex", Script: "pionex.py", Note: "Not available on TradingView"},
+	{Name: "toobit", Script: "toobit.py", Note: "Not available on TradingView"},
+}
+
+// describeFlag is the argument that makes a script print its metadata as
+// JSON instead of scraping, e.g.
+//
+//	{"exchange":"bybit","format":"keep_original","tradingview":true}
+//
+// Only scripts whose source mentions it are invoked, since a script without
+// the handler would start a full scrape.
+const describeFlag = "--describe"
+
+const describeTimeout = 15 * time.Second
+
+type scriptDescription struct {
+	Exchange    string `json:"exchange"`
+	Format      string `json:"format,omitempty"`
+	TradingView *bool  `json:"tradingview,omitempty"`
+	Enabled     *bool  `json:"enabled,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+func describeScript(interpreter, scriptDir, script string) (*scriptDescription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, interpreter, script, describeFlag)
+	cmd.Dir = scriptDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	desc := &scriptDescription{}
+	if err := json.Unmarshal(bytes.TrimSpace(out), desc); err != nil {
+		return nil, fmt.Errorf("invalid %s output: %w", describeFlag, err)
+	}
+	if desc.Exchange == "" {
+		return nil, fmt.Errorf("%s output has no exchange name", describeFlag)
+	}
+	return desc, nil
+}
+
+// discoverExchanges asks every self-describing script in scriptDir for its
+// metadata. Scripts without --describe support, or whose description fails,
+// keep their built-in roster entry; ones the roster doesn't know are listed
+// disabled under their file name, so that they show up without running.
+func discoverExchanges(interpreter, scriptDir string) []Exchange {
+	scripts, _ := filepath.Glob(filepath.Join(scriptDir, "*.py"))
+	var exchanges []Exchange
+	for _, path := range scripts {
+		script := filepath.Base(path)
+		if strings.HasPrefix(script, "test_") {
+			continue
+		}
+		var desc *scriptDescription
+		if src, err := os.ReadFile(path); err == nil && bytes.Contains(src, []byte(describeFlag)) {
+			desc, err = describeScript(interpreter, scriptDir, script)
+			if err != nil {
+				slog.Warn("cannot describe script", "script", script, "error", err)
+			}
+		}
+		if desc == nil {
+			if !slices.ContainsFunc(defaultExchanges, func(ex Exchange) bool { return ex.Script == script }) {
+				name := strings.TrimSuffix(script, ".py")
+				slog.Debug("discovered undescribed script", "exchange", name, "script", script)
+				exchanges = append(exchanges, Exchange{Name: name, Script: script, Note: "no " + describeFlag + " metadata"})
+			}
+			continue
+		}
+		ex := Exchange{Name: desc.Exchange, Script: script, Format: desc.Format, Enabled: true, Note: desc.Note}
+		if desc.TradingView != nil && !*desc.TradingView {
+			ex.Enabled = false
+			ex.Note = cmp.Or(ex.Note, "Not available on TradingView")
+		}
+		if desc.Enabled != nil {
+			ex.Enabled = *desc.Enabled
+		}
+		slog.Debug("discovered exchange", "exchange", ex.Name, "script", script, "format", ex.Format, "enabled", ex.Enabled)
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges
+}
+
+// defaultInitConfig is where -init writes when -config isn't given,
+// relative to the script directory.
+const defaultInitConfig = "run_all.json"
+
+// scaffoldConfig builds a starter config (-init) listing every *.py script
+// in scriptDir, each disabled. Formats come from --describe where scripts
+// support it, otherwise from the built-in roster entry of the same name.
+func scaffoldConfig(interpreter, scriptDir string) *config {
+	scripts, _ := filepath.Glob(filepath.Join(scriptDir, "*.py"))
+	cfg := &config{Exchanges: []exchangeConfig{}}
+	disabled := envBool(false)
+	for _, path := range scripts {
+		script := filepath.Base(path)
+		if strings.HasPrefix(script, "test_") {
+			continue
+		}
+		c := exchangeConfig{Name: strings.TrimSuffix(script, ".py"), Enabled: &disabled}
+		var format, note string
+		if i := slices.IndexFunc(defaultExchanges, func(ex Exchange) bool { return ex.Script == script }); i >= 0 {
+			c.Name, format, note = defaultExchanges[i].Name, defaultExchanges[i].Format, defaultExchanges[i].Note
+		}
+		if src, err := os.ReadFile(path); err == nil && bytes.Contains(src, []byte(describeFlag)) {
+			if desc, err := describeScript(interpreter, scriptDir, script); err == nil {
+				c.Name, format, note = desc.Exchange, desc.Format, cmp.Or(desc.Note, note)
+			} else {
+				slog.Warn("cannot describe script; guessing from its name", "script", script, "error", err)
+			}
+		}
+		c.Script = &script
+		if format != "" {
+			c.Format = &format
+		}
+		if note != "" {
+			c.Note = &note
+		}
+		cfg.Exchanges = append(cfg.Exchanges, c)
+	}
+	return cfg
+}
+
+// mergeDiscovered overlays self-described exchanges onto the roster by name,
+// keeping roster-only settings such as Output and Priority.
+func mergeDiscovered(base, discovered []Exchange) []Exchange {
+	exchanges := slices.Clone(base)
+	for _, d := range discovered {
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Name == d.Name })
+		if i < 0 {
+			exchanges = append(exchanges, d)
+			continue
+		}
+		ex := &exchanges[i]
+		ex.Script, ex.Enabled = d.Script, d.Enabled
+		ex.Format = cmp.Or(d.Format, ex.Format)
+		ex.Note = cmp.Or(d.Note, ex.Note)
+	}
+	return exchanges
+}
+
+// planEntry records the runner's decision for one exchange and why it was
+// made, so that -explain can show the same reasoning main acts on.
+type planEntry struct {
+	Exchange
+	Run    bool
+	Reason string
+}
+
+// planOptions holds the selection criteria applied by buildPlan.
+type planOptions struct {
+	// Listed, when non-empty, is the exact set and order to run (-from-file);
+	// it overrides the enabled flags and priorities.
+	Listed []string
+	Filter []string // only these exchanges, when non-empty
+	Skip   []string // never these exchanges; applied after Filter
+	// Tag, when set, must match the exchange's tags (-tag).
+	Tag tagExpr
+	// ConfigOrder is the -config file's exchange names, in file order; it
+	// breaks priority ties, ahead of exchanges the file doesn't list.
+	ConfigOrder []string
+}
+
+// tagExpr reports whether a set of tags satisfies a -tag expression.
+type tagExpr func(tags []string) bool
+
+// parseTagExpr parses a boolean expression over tag names, such as
+// "us AND (spot OR NOT high-volume)". AND binds tighter than OR; the
+// operators are case-insensitive and may also be written &&, || and !.
+func parseTagExpr(s string) (tagExpr, error) {
+	p := &tagParser{tokens: tagTokens(s)}
+	expr, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("-tag %q: %w", s, err)
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("-tag %q: unexpected %q", s, tok)
+	}
+	return expr, nil
+}
+
+func tagTokens(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		switch {
+		case s[0] == ' ' || s[0] == '\t':
+			s = s[1:]
+		case s[0] == '(' || s[0] == ')' || s[0] == '!':
+			tokens, s = append(tokens, s[:1]), s[1:]
+		case strings.HasPrefix(s, "&&") || strings.HasPrefix(s, "||"):
+			tokens, s = append(tokens, s[:2]), s[2:]
+		default:
+			n := strings.IndexAny(s, " \t()!&|")
+			if n == 0 {
+				n = 1 // a lone & or |, reported by the parser
+			} else if n < 0 {
+				n = len(s)
+			}
+			tokens, s = append(tokens, s[:n]), s[n:]
+		}
+	}
+	return tokens
+}
+
+type tagParser struct {
+	tokens []string
+}
+
+func (p *tagParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *tagParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.tokens = p.tokens[1:]
+	}
+	return tok
+}
+
+func (p *tagParser) or() (tagExpr, error) {
+	left, err := p.and()
+	for err == nil && (strings.EqualFold(p.peek(), "OR") || p.peek() == "||") {
+		p.next()
+		var right tagExpr
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(tags []string) bool { return l(tags) || right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) and() (tagExpr, error) {
+	left, err := p.not()
+	for err == nil && (strings.EqualFold(p.peek(), "AND") || p.peek() == "&&") {
+		p.next()
+		var right tagExpr
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(tags []string) bool { return l(tags) && right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) not() (tagExpr, error) {
+	switch tok := p.next(); {
+	case tok == "":
+		return nil, errors.New("unexpected end of expression")
+	case strings.EqualFold(tok, "NOT") || tok == "!":
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags []string) bool { return !inner(tags) }, nil
+	case tok == "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return inner, nil
+	case tok == ")" || tok == "&" || tok == "|" || tok == "&&" || tok == "||" ||
+		strings.EqualFold(tok, "AND") || strings.EqualFold(tok, "OR"):
+		return nil, fmt.Errorf("unexpected %q", tok)
+	default:
+		return func(tags []string) bool { return slices.Contains(tags, tok) }, nil
+	}
+}
+
+func warnUnmatched(flagName string, names []string, exchanges []Exchange) {
+	for _, name := range names {
+		if !slices.ContainsFunc(exchanges, func(ex Exchange) bool { return ex.Name == name }) {
+			slog.Warn(flagName+" name matches no exchange", "name", name)
+		}
+	}
+}
+
+func buildPlan(scriptDir string, exchanges []Exchange, opts planOptions) []planEntry {
+	warnUnmatched("-from-file", opts.Listed, exchanges)
+	warnUnmatched("-filter", opts.Filter, exchanges)
+	warnUnmatched("-skip", opts.Skip, exchanges)
+
+	if len(opts.Listed) > 0 {
+		// Listed exchanges first, in file order; the rest only appear as
+		// skipped entries.
+		rank := func(ex Exchange) int {
+			if i := slices.Index(opts.Listed, ex.Name); i >= 0 {
+				return i
+			}
+			return len(opts.Listed)
+		}
+		exchanges = slices.Clone(exchanges)
+		slices.SortStableFunc(exchanges, func(a, b Exchange) int { return rank(a) - rank(b) })
+	}
+
+	plan := make([]planEntry, 0, len(exchanges))
+	for _, ex := range exchanges {
+		entry := planEntry{Exchange: ex}
+		listed := slices.Contains(opts.Listed, ex.Name)
+		switch {
+		case len(opts.Listed) > 0 && !listed:
+			entry.Reason = "not listed in -from-file"
+		case len(opts.Filter) > 0 && !slices.Contains(opts.Filter, ex.Name):
+			entry.Reason = "not selected by -filter"
+		case slices.Contains(opts.Skip, ex.Name):
+			entry.Reason = "excluded by -skip"
+		case opts.Tag != nil && !opts.Tag(ex.Tags):
+			entry.Reason = "not matched by -tag"
+		case !ex.Enabled && !listed:
+			entry.Reason = "disabled"
+			if ex.Note != "" {
+				entry.Reason += " (" + ex.Note + ")"
+			}
+		default:
+			if _, err := os.Stat(filepath.Join(scriptDir, ex.Script)); os.IsNotExist(err) {
+				entry.Reason = "file not found"
+			} else {
+				entry.Run = true
+			}
+		}
+		plan = append(plan, entry)
+	}
+	if len(opts.Listed) == 0 {
+		rank := func(name string) int {
+			if i := slices.Index(opts.ConfigOrder, name); i >= 0 {
+				return i
+			}
+			return len(opts.ConfigOrder)
+		}
+		slices.SortStableFunc(plan, func(a, b planEntry) int {
+			return cmp.Or(cmp.Compare(b.Priority, a.Priority), cmp.Compare(rank(a.Name), rank(b.Name)))
+		})
+	}
+	return plan
+}
+
+// printPlan prints the resolved plan for -explain: the jobs in the order
+// they will start, after dependency ordering, -limit and adaptive timeouts,
+// how many run at once, and every exchange left out and why.
+func printPlan(plan []planEntry, run []Exchange, opts runOptions, parallel, perHost int, between time.Duration, batchSize int, batchPause time.Duration) {
+	var skipped []planEntry
+	for _, entry := range plan {
+		switch {
+		case !entry.Run:
+			skipped = append(skipped, entry)
+		case !slices.ContainsFunc(run, func(ex Exchange) bool { return ex.Name == entry.Name }):
+			entry.Reason = "beyond -limit"
+			skipped = append(skipped, entry)
+		}
+	}
+
+	mode := "sequential"
+	if parallel > 1 {
+		mode = fmt.Sprintf("parallel, %d at a time", parallel)
+	} else if between > 0 {
+		mode += fmt.Sprintf(", %v between scripts", between)
+	}
+	if perHost > 0 {
+		mode += fmt.Sprintf(", at most %d per host", perHost)
+	}
+	if batchSize > 0 {
+		mode += fmt.Sprintf(", in batches of %d", batchSize)
+		if batchPause > 0 {
+			mode += fmt.Sprintf(", %v apart", batchPause)
+		}
+	}
+	fmt.Fprintf(console, "Execution plan: %d to run, %d skipped (%s)\n", len(run), len(skipped), mode)
+	fmt.Fprintln(console, strings.Repeat("=", 60))
+	for i, ex := range run {
+		format := ex.Format
+		if format == "" {
+			format = "unknown"
+		}
+		limit := "none"
+		if ex.Timeout > 0 {
+			limit = ex.Timeout.String()
+		} else if opts.Timeout > 0 {
+			limit = opts.Timeout.String()
+		}
+		extra := ""
+		if perHost > 0 && ex.Host != "" {
+			extra += " host=" + ex.Host
+		}
+		if len(ex.DependsOn) > 0 {
+			extra += " after=" + strings.Join(ex.DependsOn, ",")
+		}
+		fmt.Fprintf(console, "%3d. %-15s %-18s interpreter=%s timeout=%s format=%s priority=%d%s\n",
+			i+1, ex.Name, ex.Script, opts.Interpreter, limit, format, ex.Priority, extra)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintln(console, strings.Repeat("-", 60))
+		for _, entry := range skipped {
+			fmt.Fprintf(console, "  -  %-15s skipped: %s\n", entry.Name, entry.Reason)
+		}
+	}
+}
+
+// historyRun is one past run as stored in the history file.
+type historyRun struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	// Runs recorded before these were kept have neither.
+	ScriptsCommit string          `json:"scripts_commit,omitempty"`
+	RunnerVersion string          `json:"runner_version,omitempty"`
+	Results       []historyResult `json:"results"`
+}
+
+type historyResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	// Skipped marks an exchange that didn't run, e.g. because a dependency
+	// failed or the soft deadline passed, or whose exit code mapped to skip.
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Symbols  int           `json:"symbols"`
+	Checksum string        `json:"checksum,omitempty"`
+	Format   string        `json:"format,omitempty"`
+}
+
+type history struct {
+	Runs []historyRun `json:"runs"`
+}
+
+func loadHistory(path string) (*history, error) {
+	h := &history{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("parse history %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory, so readers and interrupted writers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lockFile takes an exclusive lock by creating path, waiting up to wait for
+// another holder to release it. The returned func releases the lock.
+func lockFile(path string, wait time.Duration) (func(), error) {
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("lock %s is held by another runner (remove it if stale)", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+const historyLockWait = 10 * time.Second
+
+// appendHistory adds run to the history file under its lock. The file is
+// re-read while locked so concurrent runners don't drop each other's runs,
+// and only the newest limit runs are kept (0 keeps everything).
+func appendHistory(path string, run historyRun, limit int) error {
+	unlock, err := lockFile(path+".lock", historyLockWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	h, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	h.Runs = append(h.Runs, run)
+	if limit > 0 && len(h.Runs) > limit {
+		h.Runs = h.Runs[len(h.Runs)-limit:]
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// medianDuration returns the median duration of an exchange's successful
+// recorded runs.
+func (h *history) medianDuration(name string) (time.Duration, bool) {
+	var durations []time.Duration
+	for _, run := range h.Runs {
+		for _, r := range run.Results {
+			if r.Name == name && r.Success {
+				durations = append(durations, r.Duration)
+			}
+		}
+	}
+	if len(durations) == 0 {
+		return 0, false
+	}
+	slices.Sort(durations)
+	return durations[len(durations)/2], true
+}
+
+// lastResult returns the most recent recorded result for an exchange.
+func (h *history) lastResult(name string) (historyResult, bool) {
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		for _, r := range h.Runs[i].Results {
+			if r.Name == name {
+				return r, true
+			}
+		}
+	}
+	return historyResult{}, false
+}
+
+// failureStreak returns how many of an exchange's most recent recorded
+// results in a row are failures. Runs it is missing from, such as those it
+// was quarantined for, and runs it was skipped in don't break the streak or
+// add to it.
+func (h *history) failureStreak(name string) int {
+	streak := 0
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		j := slices.IndexFunc(h.Runs[i].Results, func(r historyResult) bool { return r.Name == name })
+		if j < 0 || h.Runs[i].Results[j].Skipped {
+			continue
+		}
+		if h.Runs[i].Results[j].Success {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// quarantine stops every planned exchange whose last after recorded results
+// all failed from running, and returns each one's failure streak. With
+// release set they run anyway, once, and are still returned: a success
+// ends the streak, a failure quarantines them again.
+func quarantine(plan []planEntry, hist *history, after int, release bool) map[string]int {
+	quarantined := map[string]int{}
+	for i, entry := range plan {
+		if !entry.Run {
+			continue
+		}
+		streak := hist.failureStreak(entry.Name)
+		if streak < after {
+			continue
+		}
+		quarantined[entry.Name] = streak
+		if release {
+			slog.Info("running quarantined exchange (-unquarantine)", "exchange", entry.Name, "failures", streak)
+			continue
+		}
+		plan[i].Run = false
+		plan[i].Reason = fmt.Sprintf("quarantined after %d consecutive failures (-unquarantine to retry)", streak)
+	}
+	return quarantined
+}
+
+func newHistoryRun(started time.Time, duration time.Duration, results []ScriptResult) historyRun {
+	run := historyRun{Started: started, Duration: duration}
+	for _, r := range results {
+		run.Results = append(run.Results, historyResult{
+			Name:     r.Name,
+			Success:  r.Success,
+			Skipped:  r.Skipped,
+			Duration: r.Duration,
+			Symbols:  r.Symbols,
+			Checksum: r.Checksum,
+			Format:   r.Format,
+		})
+	}
+	return run
+}
+
+// failing returns the sorted names of the exchanges that failed in run.
+// Ones skipped for a failed dependency or the soft deadline didn't run, so
+// they aren't failing.
+func (run historyRun) failing() []string {
+	names := []string{}
+	for _, r := range run.Results {
+		if !r.Success && !r.Skipped {
+			names = append(names, r.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// webhookPayload is the JSON body POSTed to -webhook after a run.
+type webhookPayload struct {
+	Title      string        `json:"title,omitempty"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration_ns"`
+	Successful int           `json:"successful"`
+	Failed     int           `json:"failed"`
+	Failing    []string      `json:"failing"`
+	// NewlyFailing and Recovered compare with the previous history run.
+	NewlyFailing []string `json:"newly_failing,omitempty"`
+	Recovered    []string `json:"recovered,omitempty"`
+}
+
+const webhookTimeout = 10 * time.Second
+
+func postWebhook(url string, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// otlpTimeout bounds the -otel-endpoint export, like webhookTimeout.
+const otlpTimeout = 10 * time.Second
+
+// OTLP/JSON trace documents, as accepted by any OTLP/HTTP collector on
+// /v1/traces. Only the fields run_all fills in are declared.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue; exactly one field is set. Integers are JSON
+// strings in OTLP.
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"`
+	Bool   *bool   `json:"boolValue,omitempty"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func otlpInt(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{Int: &s}}
+}
+
+func otlpBool(key string, value bool) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{Bool: &value}}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Span kinds and status codes from the OTLP protocol.
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// buildTrace turns a run into one trace: a "run" span covering the whole
+// run and, under it, an "exchange <name>" span per exchange that was
+// started, from its first attempt to the end of its last. Exchanges that
+// never started (dependency or soft-deadline skips) get no span.
+func buildTrace(title string, started time.Time, total time.Duration, results []ScriptResult) otlpTraces {
+	traceID := fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+	spanID := func() string { return fmt.Sprintf("%016x", rand.Uint64()) }
+
+	root := otlpSpan{
+		TraceID: traceID, SpanID: spanID(), Name: "run", Kind: otlpKindInternal,
+		Start: otlpTime(started), End: otlpTime(started.Add(total)),
+	}
+	root.Status.Code = otlpStatusOK
+	spans := []otlpSpan{}
+	failed := 0
+	for _, r := range results {
+		if !r.Success && !r.Partial {
+			failed++
+		}
+		if len(r.Attempts) == 0 || r.Attempts[0].Started.IsZero() {
+			continue
+		}
+		last := r.Attempts[len(r.Attempts)-1]
+		span := otlpSpan{
+			TraceID: traceID, SpanID: spanID(), ParentSpanID: root.SpanID,
+			Name: "exchange " + r.Name, Kind: otlpKindInternal,
+			Start: otlpTime(r.Attempts[0].Started), End: otlpTime(last.Started.Add(last.Duration)),
+			Attributes: []otlpAttribute{
+				otlpString("exchange", r.Name),
+				otlpBool("success", r.Success),
+				otlpInt("exit_code", last.ExitCode),
+				otlpInt("symbols", r.Symbols),
+				otlpInt("attempts", len(r.Attempts)),
+			},
+		}
+		if r.Skipped {
+			span.Attributes = append(span.Attributes, otlpBool("skipped", true))
+		}
+		if r.Partial {
+			span.Attributes = append(span.Attributes, otlpBool("partial", true))
+		}
+		span.Status.Code = otlpStatusOK
+		if !r.Success {
+			span.Status.Code, span.Status.Message = otlpStatusError, cmp.Or(r.Summary, last.Error)
+		}
+		spans = append(spans, span)
+	}
+	root.Attributes = []otlpAttribute{otlpInt("exchanges", len(results)), otlpInt("failed", failed)}
+	if title != "" {
+		root.Attributes = append(root.Attributes, otlpString("title", title))
+	}
+	if failed > 0 {
+		root.Status.Code, root.Status.Message = otlpStatusError, fmt.Sprintf("%d exchange(s) failed", failed)
+	}
+	spans = append([]otlpSpan{root}, spans...)
+
+	service := cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), "run_all")
+	rs := otlpResourceSpans{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpString("service.name", service)}},
+		ScopeSpans: []otlpScopeSpans{{Spans: spans}},
+	}
+	rs.ScopeSpans[0].Scope.Name = "run_all"
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+// otlpTracesURL resolves -otel-endpoint the way OTEL_EXPORTER_OTLP_ENDPOINT
+// is resolved: a bare collector address gets the /v1/traces path.
+func otlpTracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid -otel-endpoint %q: want an http(s) URL such as http://localhost:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// exportTrace POSTs traces as OTLP/JSON, with any OTEL_EXPORTER_OTLP_HEADERS
+// (comma-separated key=value pairs, e.g. for an API key).
+func exportTrace(url string, traces otlpTraces) error {
+	body, err := json.Marshal(traces)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for pair := range strings.SplitSeq(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			req.Header.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	client := &http.Client{Timeout: otlpTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// version is the runner's release, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version string
+
+// runnerVersion identifies this build of the runner: version, else the
+// module version or VCS revision Go stamped into the binary, else
+// "unknown" (as under go run).
+func runnerVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision == "" {
+		return "unknown"
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// scriptsCommit returns the git commit checked out in the script
+// directory, or "unknown" when it isn't a git work tree or git is missing.
+func scriptsCommit(scriptDir string) string {
+	out, err := exec.Command("git", "-C", scriptDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// sqliteSchema is created in a new -sqlite database. Times are UTC in
+// SQLite's "YYYY-MM-DD HH:MM:SS" form, so date functions work on them.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	started TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	title TEXT,
+	scripts_commit TEXT,
+	runner_version TEXT
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	exchange TEXT NOT NULL,
+	success INTEGER NOT NULL,
+	skipped INTEGER NOT NULL,
+	partial INTEGER NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	symbols INTEGER NOT NULL,
+	exit_code INTEGER,
+	attempts INTEGER NOT NULL,
+	error TEXT,
+	checksum TEXT
+);
+CREATE INDEX IF NOT EXISTS results_exchange ON results(exchange, run_id);
+`
+
+// sqlQuote returns s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "\x00", ""), "'", "''") + "'"
+}
+
+// sqlText is sqlQuote, with NULL for an empty s.
+func sqlText(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return sqlQuote(s)
+}
+
+func sqlBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// appendSQLite adds rep as one row of runs and one row of results per
+// exchange run to the SQLite database at path, creating the tables if
+// needed. There is no SQLite driver in the standard library, so the
+// statements are piped to the sqlite3 shell in a single transaction; -bail
+// makes a failing statement roll the whole run back.
+func appendSQLite(path string, rep report) error {
+	var sql strings.Builder
+	sql.WriteString(sqliteSchema)
+	sql.WriteString("BEGIN IMMEDIATE;\n")
+	fmt.Fprintf(&sql, "INSERT INTO runs (started, duration_ms, title, scripts_commit, runner_version) VALUES (%s, %d, %s, %s, %s);\n",
+		sqlQuote(rep.Started.UTC().Format(time.DateTime)), rep.Duration.Milliseconds(),
+		sqlText(rep.Title), sqlQuote(rep.ScriptsCommit), sqlQuote(rep.RunnerVersion))
+	for _, r := range rep.Results {
+		exitCode, errText := "NULL", "NULL"
+		if len(r.Attempts) > 0 {
+			exitCode = strconv.Itoa(r.Attempts[len(r.Attempts)-1].ExitCode)
+		}
+		if r.Error != nil {
+			errText = sqlQuote(r.Error.Error())
+		}
+		// The run's id is the newest one: the transaction holds the
+		// write lock.
+		fmt.Fprintf(&sql, "INSERT INTO results VALUES ((SELECT max(id) FROM runs), %s, %s, %s, %s, %d, %d, %s, %d, %s, %s);\n",
+			sqlQuote(r.Name), sqlBool(r.Success), sqlBool(r.Skipped), sqlBool(r.Partial),
+			r.Duration.Milliseconds(), r.Symbols, exitCode, len(r.Attempts), errText, sqlText(r.Checksum))
+	}
+	sql.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", "-bail", "-cmd", ".timeout "+strconv.Itoa(int(historyLockWait.Milliseconds())), path)
+	cmd.Stdin = strings.NewReader(sql.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlite3 %s: %w: %s", path, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// report is the document written by -report.
+type report struct {
+	Title    string        `json:"title,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	// ScriptsCommit is the script directory's git HEAD and RunnerVersion
+	// the runner's build, each "unknown" when it can't be told.
+	ScriptsCommit string         `json:"scripts_commit"`
+	RunnerVersion string         `json:"runner_version"`
+	Results       []ScriptResult `json:"results"`
+	DurationStats *durationStats `json:"duration_stats,omitempty"`
+	// Quarantined maps exchanges quarantined by -quarantine-after to their
+	// consecutive failures.
+	Quarantined map[string]int `json:"quarantined,omitempty"`
+}
+
+// durationStats characterises script durations across one run (-stats).
+type durationStats struct {
+	Count int           `json:"count"`
+	Total time.Duration `json:"total_ns"`
+	Min   time.Duration `json:"min_ns"`
+	Max   time.Duration `json:"max_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+}
+
+// computeDurationStats summarises the durations of the results that ran;
+// skipped ones, with no duration, are left out. It returns nil when none
+// are left.
+func computeDurationStats(results []ScriptResult) *durationStats {
+	var durations []time.Duration
+	st := &durationStats{}
+	for _, r := range results {
+		if r.Skipped {
+			continue
+		}
+		durations = append(durations, r.Duration)
+		st.Total += r.Duration
+	}
+	if len(durations) == 0 {
+		return nil
+	}
+	st.Count = len(durations)
+	slices.Sort(durations)
+	// Nearest-rank percentile.
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p / 100 * float64(len(durations))))
+		return durations[max(rank, 1)-1]
+	}
+	st.Min, st.Max = durations[0], durations[len(durations)-1]
+	st.P50, st.P90, st.P99 = percentile(50), percentile(90), percentile(99)
+	return st
+}
+
+func loadReport(path string) (*report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rep := &report{}
+	if err := json.Unmarshal(data, rep); err != nil {
+		return nil, fmt.Errorf("parse report %s: %w", path, err)
+	}
+	return rep, nil
+}
+
+// regression is one exchange that got worse than the -baseline report
+// allows.
+type regression struct {
+	Name   string
+	Metric string // "duration" or "symbols"
+	Before string
+	After  string
+	Change float64 // percent, positive is worse
+}
+
+// compareBaseline flags exchanges that are more than maxSlowdown percent
+// slower, or lost more than maxDrop percent of their symbols, compared with
+// the baseline. Exchanges absent from either side are not compared.
+func compareBaseline(base *report, results []ScriptResult, maxSlowdown, maxDrop float64) []regression {
+	var regressions []regression
+	for _, r := range results {
+		i := slices.IndexFunc(base.Results, func(b ScriptResult) bool { return b.Name == r.Name })
+		if i < 0 {
+			continue
+		}
+		b := base.Results[i]
+		if b.Duration > 0 {
+			change := (float64(r.Duration) - float64(b.Duration)) / float64(b.Duration) * 100
+			if change > maxSlowdown {
+				regressions = append(regressions, regression{
+					Name: r.Name, Metric: "duration",
+					Before: b.Duration.Round(time.Millisecond).String(),
+					After:  r.Duration.Round(time.Millisecond).String(),
+					Change: change,
+				})
+			}
+		}
+		if b.Symbols > 0 {
+			change := float64(b.Symbols-r.Symbols) / float64(b.Symbols) * 100
+			if change > maxDrop {
+				regressions = append(regressions, regression{
+					Name: r.Name, Metric: "symbols",
+					Before: strconv.Itoa(b.Symbols),
+					After:  strconv.Itoa(r.Symbols),
+					Change: change,
+				})
+			}
+		}
+	}
+	return regressions
+}
+
+func printRegressions(path string, regressions []regression) {
+	if len(regressions) == 0 {
+		fmt.Fprintf(console, "\n✓ No regressions against baseline %s\n", path)
+		return
+	}
+	fmt.Fprintf(console, "\n✗ %d regression(s) against baseline %s:\n", len(regressions), path)
+	fmt.Fprintf(console, "  %-15s %-9s %12s %12s %9s\n", "EXCHANGE", "METRIC", "BASELINE", "NOW", "CHANGE")
+	for _, r := range regressions {
+		sign := "+"
+		if r.Metric == "symbols" {
+			sign = "-"
+		}
+		fmt.Fprintf(console, "  %-15s %-9s %12s %12s %8s%%\n", r.Name, r.Metric, r.Before, r.After, sign+strconv.FormatFloat(r.Change, 'f', 1, 64))
+	}
+}
+
+// templateData is what a -template renders: the run's metadata, its
+// results in run order and the counts the built-in summary prints.
+type templateData struct {
+	Title         string
+	Started       time.Time
+	Duration      time.Duration
+	ScriptsCommit string
+	RunnerVersion string
+	Results       []ScriptResult
+	Successful    int
+	Failed        int
+	Partial       int
+	Skipped       int
+	Symbols       int
+	ExitCode      int
+}
+
+// resultStatus is how the summary classifies a result: "skipped",
+// "partial", "success" or "failed".
+func resultStatus(r ScriptResult) string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Partial:
+		return "partial"
+	case r.Success:
+		return "success"
+	default:
+		return "failed"
+	}
+}
+
+// templateFuncs are the helpers available to -template, beyond the
+// text/template built-ins.
+var templateFuncs = template.FuncMap{
+	// duration rounds to milliseconds: {{duration .Duration}} is "1.234s".
+	"duration": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"seconds":  func(d time.Duration) float64 { return d.Seconds() },
+	"status":   resultStatus,
+	// only keeps the results with one of the given statuses:
+	// {{range only .Results "failed" "partial"}}.
+	"only": func(results []ScriptResult, statuses ...string) []ScriptResult {
+		var kept []ScriptResult
+		for _, r := range results {
+			if slices.Contains(statuses, resultStatus(r)) {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	},
+	"count": func(results []ScriptResult, status string) int {
+		n := 0
+		for _, r := range results {
+			if resultStatus(r) == status {
+				n++
+			}
+		}
+		return n
+	},
+	"pad":  func(width int, s string) string { return fmt.Sprintf("%-*s", width, s) },
+	"join": strings.Join,
+	"percent": func(part, whole int) float64 {
+		if whole == 0 {
+			return 0
+		}
+		return float64(part) / float64(whole) * 100
+	},
+}
+
+func loadTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// newFailures splits a run's failures by the baseline: those that passed
+// in base, or that it doesn't have, are fresh; those that failed there
+// too are known. With -repeat-each the last result of each name counts.
+func newFailures(base *report, results []ScriptResult) (fresh, known []string) {
+	passed := map[string]bool{}
+	for _, r := range base.Results {
+		passed[r.Name] = r.Success
+	}
+	for _, r := range results {
+		if r.Success || r.Skipped || r.Partial || slices.Contains(fresh, r.Name) || slices.Contains(known, r.Name) {
+			continue
+		}
+		if ok, seen := passed[r.Name]; seen && !ok {
+			known = append(known, r.Name)
+		} else {
+			fresh = append(fresh, r.Name)
+		}
+	}
+	return fresh, known
+}
+
+// compareReports prints how the exchanges in a newer report differ from an
+// older one (-compare): status flips first, then per-exchange duration and
+// symbol changes.
+func compareReports(w io.Writer, oldPath, newPath string, old, cur *report) {
+	fmt.Fprintf(w, "Comparing %s → %s\n", oldPath, newPath)
+	find := func(rep *report, name string) (ScriptResult, bool) {
+		i := slices.IndexFunc(rep.Results, func(r ScriptResult) bool { return r.Name == name })
+		if i < 0 {
+			return ScriptResult{}, false
+		}
+		return rep.Results[i], true
+	}
+
+	var names []string
+	for _, r := range slices.Concat(old.Results, cur.Results) {
+		if !slices.Contains(names, r.Name) {
+			names = append(names, r.Name)
+		}
+	}
+	var started, stopped, added, removed []string
+	for _, name := range names {
+		o, inOld := find(old, name)
+		n, inNew := find(cur, name)
+		switch {
+		case !inOld:
+			added = append(added, name)
+		case !inNew:
+			removed = append(removed, name)
+		case o.Success && !n.Success:
+			started = append(started, name)
+		case !o.Success && n.Success:
+			stopped = append(stopped, name)
+		}
+	}
+	for _, group := range []struct {
+		label string
+		names []string
+	}{
+		{"✗ Started failing", started},
+		{"✓ Stopped failing", stopped},
+		{"+ Only in new", added},
+		{"- Only in old", removed},
+	} {
+		if len(group.names) > 0 {
+			fmt.Fprintf(w, "%s: %s\n", group.label, strings.Join(group.names, ", "))
+		}
+	}
+
+	fmt.Fprintf(w, "\n  %-15s %12s %12s %9s %8s %8s %7s\n", "EXCHANGE", "OLD", "NEW", "CHANGE", "OLD SYM", "NEW SYM", "DELTA")
+	for _, name := range names {
+		o, inOld := find(old, name)
+		n, inNew := find(cur, name)
+		if !inOld || !inNew {
+			continue
+		}
+		change := "-"
+		if o.Duration > 0 {
+			change = fmt.Sprintf("%+.1f%%", (float64(n.Duration)-float64(o.Duration))/float64(o.Duration)*100)
+		}
+		fmt.Fprintf(w, "  %-15s %12v %12v %9s %8d %8d %+7d\n", name,
+			o.Duration.Round(time.Millisecond), n.Duration.Round(time.Millisecond), change,
+			o.Symbols, n.Symbols, n.Symbols-o.Symbols)
+	}
+}
+
+// normalizeSymbol applies an exchange's symbol Format: remove_dash turns
+// BTC-USDT into BTCUSDT, anything else keeps the symbol as written.
+func normalizeSymbol(format, symbol string) string {
+	if format == "remove_dash" {
+		return strings.ReplaceAll(symbol, "-", "")
+	}
+	return symbol
+}
+
+// formatMismatch reports the first symbol that contradicts a declared
+// format, or "" when they all fit or the format promises nothing.
+func formatMismatch(format string, symbols []string) string {
+	switch format {
+	case "remove_dash":
+		if i := slices.IndexFunc(symbols, func(s string) bool { return strings.Contains(s, "-") }); i >= 0 {
+			return fmt.Sprintf("remove_dash, but %s has a dash", symbols[i])
+		}
+	}
+	return ""
+}
+
+// normalize applies the exchange's Format and then its normalize rules.
+func (ex Exchange) normalize(symbol string) string {
+	symbol = normalizeSymbol(ex.Format, symbol)
+	if ex.Normalize != nil {
+		symbol = ex.Normalize.apply(symbol)
+	}
+	return symbol
+}
+
+// symbolKey is what symbols are compared by across exchanges: BTC-USDT,
+// btc_usdt and BTC/USDT all become BTCUSDT.
+func symbolKey(symbol string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", "_", "", "/", "").Replace(symbol))
+}
+
+// symbolConflict is a symbol that successful exchanges spell differently
+// even after normalizing; Variants maps each spelling to the exchanges
+// using it.
+type symbolConflict struct {
+	Key      string
+	Variants map[string][]string
+}
+
+// findSymbolConflicts groups the symbols of every successful exchange by
+// symbolKey, after each exchange's normalization, and returns the groups
+// with more than one spelling, by key.
+func findSymbolConflicts(scriptDir string, exchanges []Exchange, results []ScriptResult, format string) []symbolConflict {
+	variants := map[string]map[string][]string{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Name == r.Name })
+		if !r.Success || r.Skipped || i < 0 || seen[r.Name] {
+			continue
+		}
+		seen[r.Name] = true
+		ex := exchanges[i]
+		symbols, _ := readSymbols(filepath.Join(scriptDir, ex.OutputDir()), format)
+		for _, symbol := range symbols {
+			symbol = ex.normalize(symbol)
+			key := symbolKey(symbol)
+			if variants[key] == nil {
+				variants[key] = map[string][]string{}
+			}
+			if !slices.Contains(variants[key][symbol], ex.Name) {
+				variants[key][symbol] = append(variants[key][symbol], ex.Name)
+			}
+		}
+	}
+	var conflicts []symbolConflict
+	for _, key := range slices.Sorted(maps.Keys(variants)) {
+		if len(variants[key]) > 1 {
+			conflicts = append(conflicts, symbolConflict{Key: key, Variants: variants[key]})
+		}
+	}
+	return conflicts
+}
+
+// maxConflictsShown caps the -symbol-conflicts listing.
+const maxConflictsShown = 20
+
+func printSymbolConflicts(conflicts []symbolConflict) {
+	if len(conflicts) == 0 {
+		fmt.Fprintln(console, "\n🔀 No symbol spelled differently across exchanges")
+		return
+	}
+	fmt.Fprintf(console, "\n🔀 %d symbol(s) spelled differently across exchanges:\n", len(conflicts))
+	for _, c := range conflicts[:min(len(conflicts), maxConflictsShown)] {
+		var parts []string
+		for _, spelling := range slices.Sorted(maps.Keys(c.Variants)) {
+			parts = append(parts, fmt.Sprintf("%s (%s)", spelling, strings.Join(c.Variants[spelling], ", ")))
+		}
+		fmt.Fprintf(console, "  %-12s %s\n", c.Key, strings.Join(parts, " vs "))
+	}
+	if more := len(conflicts) - maxConflictsShown; more > 0 {
+		fmt.Fprintf(console, "  ... and %d more\n", more)
+	}
+}
+
+// writeMerged writes the symbols of every successful exchange to one file
+// with an exchange column: NDJSON when path ends in .ndjson or .jsonl, CSV
+// otherwise. Failed exchanges are left out since their output may be
+// partial.
+//
+// This is the only writer of the merged file: it runs once every script has
+// finished, reads each exchange's own output directory in turn and replaces
+// path atomically, so parallel scripts never touch it and concurrent runners
+// sharing a path leave one run's complete output, never an interleaving.
+// Scripts should write only their own directory (see sharedOutputs).
+func writeMerged(path, scriptDir string, exchanges []Exchange, results []ScriptResult, format string) (int, error) {
+	var buf bytes.Buffer
+	ndjson := strings.HasSuffix(path, ".ndjson") || strings.HasSuffix(path, ".jsonl")
+	w := csv.NewWriter(&buf)
+	if !ndjson {
+		w.Write([]string{"exchange", "symbol"})
+	}
+	rows := 0
+	for _, r := range results {
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Name == r.Name })
+		if !r.Success || r.Skipped || i < 0 {
+			continue
+		}
+		ex := exchanges[i]
+		symbols, err := readSymbols(filepath.Join(scriptDir, ex.OutputDir()), format)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", ex.Name, err)
+		}
+		for _, symbol := range symbols {
+			symbol = ex.normalize(symbol)
+			if ndjson {
+				line, _ := json.Marshal(map[string]string{"exchange": ex.Name, "symbol": symbol})
+				buf.Write(append(line, '\n'))
+			} else {
+				w.Write([]string{ex.Name, symbol})
+			}
+			rows++
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, err
+	}
+	return rows, writeFileAtomic(path, buf.Bytes())
+}
+
+// sharedOutputs returns the output directories, relative to scriptDir,
+// that more than one exchange of the run writes to, with those exchanges.
+// Parallel scripts would clobber each other's files there, and -merge
+// would credit every exchange with all of them.
+func sharedOutputs(scriptDir string, exchanges []Exchange) map[string][]string {
+	writers := map[string][]string{}
+	for _, ex := range exchanges {
+		dir := filepath.Clean(filepath.Join(scriptDir, ex.OutputDir()))
+		writers[dir] = append(writers[dir], ex.Name)
+	}
+	shared := map[string][]string{}
+	for dir, names := range writers {
+		if len(names) > 1 {
+			rel, err := filepath.Rel(scriptDir, dir)
+			if err != nil {
+				rel = dir
+			}
+			shared[rel] = names
+		}
+	}
+	return shared
+}
+
+func writeReport(path string, rep report) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// cleanFailuresDir empties dir for -failures-dir, creating it if needed, so
+// it holds only the failures of the run about to start.
+func cleanFailuresDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+// writeFailures writes <dir>/<exchange>.txt for every failed result, with
+// the error, exit code, category, traceback and output tail, for CI to
+// upload as separate artifacts.
+func writeFailures(dir string, results []ScriptResult) (int, error) {
+	n := 0
+	for _, r := range results {
+		if resultStatus(r) != "failed" {
+			continue
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "exchange: %s\n", r.Name)
+		if r.Error != nil {
+			fmt.Fprintf(&b, "error: %v\n", r.Error)
+		}
+		exitCode := r.exitCode
+		if len(r.Attempts) > 0 {
+			exitCode = r.Attempts[len(r.Attempts)-1].ExitCode
+		}
+		fmt.Fprintf(&b, "exit code: %d\n", exitCode)
+		if category, suggestion, ok := explainFailure(r); ok {
+			fmt.Fprintf(&b, "category: %s\nsuggestion: %s\n", category, suggestion)
+		}
+		fmt.Fprintf(&b, "attempts: %d\nduration: %v\n", max(len(r.Attempts), 1), r.Duration)
+		if r.LogFile != "" {
+			fmt.Fprintf(&b, "log file: %s\n", r.LogFile)
+		}
+		if tb, _ := lastTraceback(r.StderrText); tb != "" {
+			fmt.Fprintf(&b, "\n--- traceback ---\n%s\n", strings.TrimRight(tb, "\r\n"))
+		}
+		if r.Output != "" {
+			fmt.Fprintf(&b, "\n--- output (tail) ---\n%s\n", strings.TrimRight(r.Output, "\n"))
+		}
+		if err := writeFileAtomic(filepath.Join(dir, r.Name+".txt"), []byte(b.String())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// runIDLayout names the per-run directories of -runs-dir, so that they sort
+// by start time.
+const runIDLayout = "20060102-150405"
+
+// newRunDir creates base/<run-id> for a run starting at started; runs
+// started within the same second get a -2, -3, ... suffix.
+func newRunDir(base string, started time.Time) (string, error) {
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return "", err
+	}
+	id := started.Format(runIDLayout)
+	for n := 2; ; n++ {
+		dir := filepath.Join(base, id)
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		id = fmt.Sprintf("%s-%d", started.Format(runIDLayout), n)
+	}
+}
+
+// isRunDir reports whether name was made by newRunDir.
+func isRunDir(name string) bool {
+	if len(name) < len(runIDLayout) {
+		return false
+	}
+	_, err := time.Parse(runIDLayout, name[:len(runIDLayout)])
+	return err == nil
+}
+
+// archiveRun copies each exchange's output directory into runDir and
+// writes the run's report there. Scripts write their output in place, so
+// a copy is the only way to keep it per run.
+func archiveRun(runDir, scriptDir string, exchanges []Exchange, rep report) {
+	for _, ex := range exchanges {
+		src := filepath.Join(scriptDir, ex.OutputDir())
+		if !fileExists(src) {
+			continue
+		}
+		if err := copyDir(src, filepath.Join(runDir, ex.OutputDir())); err != nil {
+			slog.Error("failed to archive output", "exchange", ex.Name, "dir", runDir, "error", err)
+		}
+	}
+	if err := writeReport(filepath.Join(runDir, "report.json"), rep); err != nil {
+		slog.Error("failed to write report", "dir", runDir, "error", err)
+	}
+}
+
+// copyDir copies the regular files below src into dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// updateLatestLink points base/latest at runDir, replacing the old link in
+// one rename so that readers always find a valid one.
+func updateLatestLink(base, runDir string) error {
+	tmp := filepath.Join(base, ".latest.tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(runDir), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(base, "latest"))
+}
+
+// pruneRuns deletes all but the newest keep run directories under base.
+func pruneRuns(base string, keep int) {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		slog.Error("cannot prune runs", "base", base, "error", err)
+		return
+	}
+	var runs []string
+	for _, entry := range entries {
+		if entry.IsDir() && isRunDir(entry.Name()) {
+			runs = append(runs, entry.Name())
+		}
+	}
+	// ReadDir sorts by name, which for run IDs is oldest first.
+	for _, name := range runs[:max(len(runs)-keep, 0)] {
+		if err := os.RemoveAll(filepath.Join(base, name)); err != nil {
+			slog.Error("failed to prune run", "dir", name, "error", err)
+			continue
+		}
+		slog.Info("pruned old run", "dir", name)
+	}
+}
+
+// statusShutdownWait bounds how long in-flight status requests may take
+// once the daemon is stopping.
+const statusShutdownWait = 5 * time.Second
+
+// runStatus is the document served at /status in -repeat mode.
+type runStatus struct {
+	Runs     int       `json:"runs"`
+	ExitCode int       `json:"exit_code"`
+	NextRun  time.Time `json:"next_run,omitzero"`
+	Latest   *report   `json:"latest,omitempty"`
+}
+
+// statusServer serves the latest -repeat run over HTTP (-http):
+// /status returns its JSON summary, /healthz answers as long as the
+// daemon is up.
+type statusServer struct {
+	srv    *http.Server
+	mu     sync.Mutex
+	status runStatus
+}
+
+func startStatusServer(addr string) (*statusServer, error) {
+	s := &statusServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		data, err := json.MarshalIndent(s.status, "", "  ")
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	// Listen up front so a taken port fails the start, not a goroutine.
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("status server stopped", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("serving status", "addr", ln.Addr().String())
+	return s, nil
+}
+
+// update records a finished run and when the next one is due.
+func (s *statusServer) update(rep report, exitCode int, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Runs++
+	s.status.ExitCode = exitCode
+	s.status.NextRun = next
+	s.status.Latest = &rep
+}
+
+func (s *statusServer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), statusShutdownWait)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		slog.Warn("status server shutdown", "error", err)
+	}
+}
+
+// symbolCount is what -count-only reports: a script's own "::stat symbols=N"
+// when it printed one (scripts honouring COUNT_ONLY write no data files),
+// otherwise the number of data files it wrote.
+func symbolCount(r ScriptResult) int {
+	if n, ok := r.Stats["symbols"]; ok {
+		return int(n)
+	}
+	return r.Symbols
+}
+
+// printFlakiness summarises repeated runs of the same exchanges
+// (-repeat-each): pass rate and the spread of their durations.
+func printFlakiness(results []ScriptResult) {
+	var names []string
+	runs := map[string][]ScriptResult{}
+	for _, r := range results {
+		if _, ok := runs[r.Name]; !ok {
+			names = append(names, r.Name)
+		}
+		runs[r.Name] = append(runs[r.Name], r)
+	}
+	fmt.Fprintln(console, "\nFlakiness:")
+	fmt.Fprintf(console, "  %-15s %9s %12s %12s %12s %12s\n", "EXCHANGE", "PASS", "MIN", "AVG", "MAX", "STDDEV")
+	for _, name := range names {
+		passed := 0
+		var total time.Duration
+		lo, hi := time.Duration(math.MaxInt64), time.Duration(0)
+		for _, r := range runs[name] {
+			if r.Success {
+				passed++
+			}
+			total += r.Duration
+			lo, hi = min(lo, r.Duration), max(hi, r.Duration)
+		}
+		n := len(runs[name])
+		avg := total / time.Duration(n)
+		var variance float64
+		for _, r := range runs[name] {
+			d := float64(r.Duration - avg)
+			variance += d * d
+		}
+		stddev := time.Duration(math.Sqrt(variance / float64(n)))
+		marker := " "
+		if passed > 0 && passed < n {
+			marker = "⚠"
+		}
+		fmt.Fprintf(console, "%s %-15s %9s %12v %12v %12v %12v\n", marker, name, fmt.Sprintf("%d/%d", passed, n),
+			lo.Round(time.Millisecond), avg.Round(time.Millisecond), hi.Round(time.Millisecond), stddev.Round(time.Millisecond))
+	}
+}
+
+func printCounts(w io.Writer, results []ScriptResult) {
+	total := 0
+	fmt.Fprintf(w, "%-15s %8s\n", "EXCHANGE", "SYMBOLS")
+	for _, r := range results {
+		if !r.Success {
+			fmt.Fprintf(w, "%-15s %8s  (%v)\n", r.Name, "FAILED", r.Error)
+			continue
+		}
+		n := symbolCount(r)
+		total += n
+		fmt.Fprintf(w, "%-15s %8d\n", r.Name, n)
+	}
+	fmt.Fprintf(w, "%-15s %8d\n", "TOTAL", total)
+}
+
+// schedule runs fn for each exchange with at most parallel in flight and at
+// most perHost (0 = unlimited) from the same host group. Scripts start in
+// roster order, except that one whose host group is full is passed over
+// until a slot in its group frees up, so a busy host doesn't hold back the
+// others. The global limit always applies; perHost only narrows it.
+//
+// A script also waits for the exchanges it dependsOn (see jobDeps); if one
+// of them failed it is not run but recorded as skipped.
+//
+// done is called from the scheduling goroutine, in completion order, so it
+// needs no locking. Once stop reports true no further scripts are started;
+// the returned count says how many were started or skipped.
+func schedule(exchanges []Exchange, parallel, perHost int, stop func() bool, fn func(i int, ex Exchange) ScriptResult, done func(i int, r ScriptResult)) int {
+	type completion struct {
+		i int
+		r ScriptResult
+	}
+	parallel = max(parallel, 1)
+	deps := jobDeps(exchanges)
+	finished := make(chan completion)
+	busy := map[string]int{}
+	succeeded := map[int]bool{}
+	completed := map[int]bool{}
+	pending := make([]int, len(exchanges))
+	for i := range pending {
+		pending[i] = i
+	}
+	started, running := 0, 0
+	for {
+		for running < parallel && len(pending) > 0 && !stop() {
+			k := slices.IndexFunc(pending, func(i int) bool {
+				for _, d := range deps[i] {
+					if !completed[d] {
+						return false
+					}
+				}
+				return perHost <= 0 || busy[exchanges[i].HostGroup()] < perHost
+			})
+			if k < 0 {
+				break
+			}
+			i := pending[k]
+			pending = slices.Delete(pending, k, k+1)
+			started++
+			if d := slices.IndexFunc(deps[i], func(d int) bool { return !succeeded[d] }); d >= 0 {
+				dep := exchanges[deps[i][d]].Name
+				fmt.Fprintf(console, "⏭ Skipping %s: dependency %s failed\n", exchanges[i].Name, dep)
+				completed[i] = true
+				done(i, ScriptResult{Name: exchanges[i].Name, Skipped: true, Error: fmt.Errorf("dependency %s failed", dep)})
+				continue
+			}
+			busy[exchanges[i].HostGroup()]++
+			running++
+			go func() { finished <- completion{i, fn(i, exchanges[i])} }()
+		}
+		if running == 0 {
+			return started
+		}
+		c := <-finished
+		running--
+		busy[exchanges[c.i].HostGroup()]--
+		completed[c.i], succeeded[c.i] = true, c.r.Success
+		done(c.i, c.r)
+	}
+}
+
+// estimateSchedule replays schedule's rules on a virtual clock, with every
+// job taking its estimated duration and succeeding, and returns when each
+// job would start and the wall-clock time of the whole run. between is the
+// pause sequential runs take before every job but the first.
+func estimateSchedule(jobs []Exchange, durations []time.Duration, parallel, perHost int, between time.Duration) ([]time.Duration, time.Duration) {
+	parallel = max(parallel, 1)
+	deps := jobDeps(jobs)
+	starts := make([]time.Duration, len(jobs))
+	ends := map[int]time.Duration{} // running jobs
+	busy := map[string]int{}
+	completed := map[int]bool{}
+	pending := make([]int, len(jobs))
+	for i := range pending {
+		pending[i] = i
+	}
+	var now time.Duration
+	for {
+		for len(ends) < parallel && len(pending) > 0 {
+			k := slices.IndexFunc(pending, func(i int) bool {
+				for _, d := range deps[i] {
+					if !completed[d] {
+						return false
+					}
+				}
+				return perHost <= 0 || busy[jobs[i].HostGroup()] < perHost
+			})
+			if k < 0 {
+				break
+			}
+			i := pending[k]
+			pending = slices.Delete(pending, k, k+1)
+			if between > 0 && i > 0 {
+				now += between
+			}
+			starts[i] = now
+			ends[i] = now + durations[i]
+			busy[jobs[i].HostGroup()]++
+		}
+		if len(ends) == 0 {
+			return starts, now
+		}
+		// Like schedule, take one completion at a time, earliest first.
+		next := -1
+		for i, end := range ends {
+			if next < 0 || end < ends[next] || (end == ends[next] && i < next) {
+				next = i
+			}
+		}
+		now = ends[next]
+		delete(ends, next)
+		busy[jobs[next].HostGroup()]--
+		completed[next] = true
+	}
+}
+
+// printEstimate prints -estimate: each job's expected duration, from the
+// median of its successful history runs or fallback, and the run's
+// sequential total and predicted wall clock. With batchSize set the
+// batches are estimated one after another, batchPause apart.
+func printEstimate(jobs []Exchange, hist *history, fallback time.Duration, parallel, perHost int, between time.Duration, batchSize int, batchPause time.Duration) {
+	durations := make([]time.Duration, len(jobs))
+	sources := make([]string, len(jobs))
+	var total time.Duration
+	for i, ex := range jobs {
+		durations[i], sources[i] = fallback, "no history; -estimate-default"
+		if hist != nil {
+			if median, ok := hist.medianDuration(ex.Name); ok {
+				durations[i], sources[i] = median, "history median"
+			}
+		}
+		total += durations[i]
+	}
+	var starts []time.Duration
+	var wall time.Duration
+	if batchSize <= 0 {
+		starts, wall = estimateSchedule(jobs, durations, parallel, perHost, between)
+	}
+	for first := 0; batchSize > 0 && first < len(jobs); first += batchSize {
+		if first > 0 {
+			wall += batchPause
+		}
+		end := min(first+batchSize, len(jobs))
+		batchStarts, batchWall := estimateSchedule(jobs[first:end], durations[first:end], parallel, perHost, between)
+		for _, s := range batchStarts {
+			starts = append(starts, wall+s)
+		}
+		wall += batchWall
+	}
+
+	fmt.Fprintf(console, "⏱ Estimate for %d exchange(s), %d at a time:\n", len(jobs), max(parallel, 1))
+	fmt.Fprintf(console, "  %-15s %12s %12s  %s\n", "EXCHANGE", "DURATION", "STARTS AT", "FROM")
+	for i, ex := range jobs {
+		fmt.Fprintf(console, "  %-15s %12v %12v  %s\n", ex.Name, durations[i].Round(time.Millisecond), starts[i].Round(time.Millisecond), sources[i])
+	}
+	fmt.Fprintln(console, strings.Repeat("-", 60))
+	fmt.Fprintf(console, "Sequential total: %v\n", total.Round(time.Millisecond))
+	fmt.Fprintf(console, "Estimated wall clock: %v (finishing around %s)\n", wall.Round(time.Millisecond), time.Now().Add(wall).Format("15:04"))
+	fmt.Fprintln(console, "Retries, timeouts and failures are not modelled.")
+}
+
+// jobDeps resolves each job's DependsOn to the indices of the jobs it waits
+// for. Dependencies that aren't part of the run are ignored.
+func jobDeps(jobs []Exchange) [][]int {
+	deps := make([][]int, len(jobs))
+	for i, ex := range jobs {
+		for j, other := range jobs {
+			if other.Name != ex.Name && slices.Contains(ex.DependsOn, other.Name) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	return deps
+}
+
+// sortByDependencies orders exchanges so that each comes after those it
+// dependsOn, otherwise keeping their order. It fails on a dependency cycle.
+func sortByDependencies(exchanges []Exchange) ([]Exchange, error) {
+	sorted := make([]Exchange, 0, len(exchanges))
+	placed := map[string]bool{}
+	remaining := slices.Clone(exchanges)
+	selected := func(name string) bool {
+		return slices.ContainsFunc(exchanges, func(ex Exchange) bool { return ex.Name == name })
+	}
+	for len(remaining) > 0 {
+		k := slices.IndexFunc(remaining, func(ex Exchange) bool {
+			for _, dep := range ex.DependsOn {
+				if selected(dep) && !placed[dep] {
+					return false
+				}
+			}
+			return true
+		})
+		if k < 0 {
+			var names []string
+			for _, ex := range remaining {
+				names = append(names, ex.Name)
+			}
+			return nil, fmt.Errorf("dependency cycle among %s", strings.Join(names, ", "))
+		}
+		placed[remaining[k].Name] = true
+		sorted = append(sorted, remaining[k])
+		remaining = slices.Delete(remaining, k, k+1)
+	}
+	return sorted, nil
+}
+
+// Runner executes a list of scripts. Run honours its context throughout:
+// once it is done no further scripts start, running ones are terminated
+// through the usual SIGTERM/SIGKILL path, and Run returns the results of
+// the scripts that did run after all of them have exited.
+type Runner struct {
+	ScriptDir string
+	Jobs      []Exchange // in start order; an exchange may repeat
+	Options   runOptions
+	Retry     *retryPolicy
+	// Parallel and MaxPerHost are passed to schedule.
+	Parallel   int
+	MaxPerHost int
+	// Between is the pause before each script after the first, for
+	// sequential runs.
+	Between time.Duration
+	// CancelFile, when it appears, stops further launches like ctx does.
+	CancelFile string
+	// SoftDeadline, when non-zero, stops further launches once the run has
+	// taken this long; running scripts finish and the rest are recorded
+	// as skipped.
+	SoftDeadline time.Duration
+	// OnResult, when set, sees each result as it completes, before it is
+	// stored, from a single goroutine.
+	OnResult func(*ScriptResult)
+
+	// BatchSize, when positive, runs Jobs in successive batches of this
+	// many, each started only once the previous one has finished, and
+	// BatchPause is the wait between two batches.
+	BatchSize  int
+	BatchPause time.Duration
+
+	// Paused is the time Run spent in Between and BatchPause pauses;
+	// Batches times each batch of the last Run.
+	Paused  time.Duration
+	Batches []batchTiming
+
+	// mu guards the progress of the current Run for WriteProgress.
+	mu      sync.Mutex
+	started time.Time
+	states  []jobState
+}
+
+// batchTiming is one -batch-size batch of a Run.
+type batchTiming struct {
+	Jobs     int
+	Duration time.Duration
+}
+
+// jobState is where one job of a Run stands.
+type jobState struct {
+	started time.Time // zero while pending
+	done    bool
+	success bool
+}
+
+// WriteProgress prints a snapshot of the current Run: what is done,
+// running and pending, and the elapsed time. It is safe to call at any
+// time from any goroutine.
+func (r *Runner) WriteProgress(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		fmt.Fprintln(w, "📊 No run in progress")
+		return
+	}
+	var done, failed int
+	var running, pending []string
+	for i, st := range r.states {
+		name := r.Jobs[i].Name
+		switch {
+		case st.done:
+			done++
+			if !st.success {
+				failed++
+			}
+		case !st.started.IsZero():
+			running = append(running, fmt.Sprintf("%s (%v)", name, time.Since(st.started).Round(time.Second)))
+		default:
+			pending = append(pending, name)
+		}
+	}
+	fmt.Fprintf(w, "📊 Progress after %v: %d done (%d failed), %d running, %d pending\n",
+		time.Since(r.started).Round(time.Second), done, failed, len(running), len(pending))
+	if len(running) > 0 {
+		fmt.Fprintf(w, "   running: %s\n", strings.Join(running, ", "))
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(w, "   pending: %s\n", strings.Join(pending, ", "))
+	}
+}
+
+func (r *Runner) Run(ctx context.Context) []ScriptResult {
+	results := make([]*ScriptResult, len(r.Jobs))
+	completed := 0
+	r.mu.Lock()
+	r.started, r.states = time.Now(), make([]jobState, len(r.Jobs))
+	start := r.started
+	r.mu.Unlock()
+
+	pastDeadline := func() bool {
+		return r.SoftDeadline > 0 && time.Since(start) >= r.SoftDeadline
+	}
+	// The watcher polls; check the cancel file directly too so no new
+	// script starts in between.
+	stopping := func() bool {
+		return ctx.Err() != nil || (r.CancelFile != "" && fileExists(r.CancelFile)) || pastDeadline()
+	}
+	run := func(i int, ex Exchange) ScriptResult {
+		if r.Between > 0 && i > 0 {
+			fmt.Fprintf(console, "⏸ Pausing %v before %s\n", r.Between, ex.Name)
+			pauseStart := time.Now()
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.Between):
+			}
+			r.Paused += time.Since(pauseStart)
+		}
+		r.mu.Lock()
+		r.states[i].started = time.Now()
+		r.mu.Unlock()
+		return runWithRetries(ctx, r.ScriptDir, ex, r.Options, r.Retry, i+1, len(r.Jobs))
+	}
+	finish := func(i int, result ScriptResult) {
+		if r.OnResult != nil {
+			r.OnResult(&result)
+		}
+		results[i] = &result
+		completed++
+		r.mu.Lock()
+		r.states[i].done, r.states[i].success = true, result.Success
+		r.mu.Unlock()
+		if completed < len(r.Jobs) {
+			fmt.Fprintln(console)
+		}
+	}
+	started := 0
+	r.Batches = nil
+	if r.BatchSize <= 0 {
+		started = schedule(r.Jobs, r.Parallel, r.MaxPerHost, stopping, run, finish)
+	}
+	batches := (len(r.Jobs) + max(r.BatchSize, 1) - 1) / max(r.BatchSize, 1)
+	for first := 0; r.BatchSize > 0 && first < len(r.Jobs) && !stopping(); first += r.BatchSize {
+		if first > 0 && r.BatchPause > 0 {
+			fmt.Fprintf(console, "⏸ Pausing %v before the next batch\n", r.BatchPause)
+			pauseStart := time.Now()
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.BatchPause):
+			}
+			r.Paused += time.Since(pauseStart)
+			if stopping() {
+				break
+			}
+		}
+		end := min(first+r.BatchSize, len(r.Jobs))
+		fmt.Fprintf(console, "📦 Batch %d/%d: %d script(s)\n\n", len(r.Batches)+1, batches, end-first)
+		batchStart := time.Now()
+		// schedule only sees this batch, so failed dependencies from
+		// earlier ones are handled here, the way schedule would.
+		var jobs []Exchange
+		var index []int
+		for i := first; i < end; i++ {
+			ex := r.Jobs[i]
+			if j := slices.IndexFunc(results[:first], func(res *ScriptResult) bool {
+				return res != nil && !res.Success && slices.Contains(ex.DependsOn, res.Name)
+			}); j >= 0 {
+				fmt.Fprintf(console, "⏭ Skipping %s: dependency %s failed\n", ex.Name, results[j].Name)
+				finish(i, ScriptResult{Name: ex.Name, Skipped: true, Error: fmt.Errorf("dependency %s failed", results[j].Name)})
+				started++
+				continue
+			}
+			jobs, index = append(jobs, ex), append(index, i)
+		}
+		started += schedule(jobs, r.Parallel, r.MaxPerHost, stopping,
+			func(k int, ex Exchange) ScriptResult { return run(index[k], ex) },
+			func(k int, result ScriptResult) { finish(index[k], result) })
+		r.Batches = append(r.Batches, batchTiming{Jobs: end - first, Duration: time.Since(batchStart)})
+	}
+	switch {
+	case started == len(r.Jobs):
+	case ctx.Err() == nil && pastDeadline():
+		fmt.Fprintf(console, "\n⏰ Soft deadline of %v reached; %d script(s) not started\n", r.SoftDeadline, len(r.Jobs)-started)
+		for i, ex := range r.Jobs {
+			if results[i] == nil {
+				results[i] = &ScriptResult{Name: ex.Name, Skipped: true, Error: errors.New("soft deadline"), Format: ex.Format}
+			}
+		}
+	default:
+		fmt.Fprintf(console, "\n⚠ Run interrupted; %d script(s) not started\n", len(r.Jobs)-started)
+		if cause := context.Cause(ctx); cause != nil {
+			slog.Warn("run stopped early", "reason", cause, "not_started", len(r.Jobs)-started)
+		}
+	}
+
+	// Results are reported in job order whatever order they finished in.
+	var scriptResults []ScriptResult
+	for _, result := range results {
+		if result != nil {
+			scriptResults = append(scriptResults, *result)
+		}
+	}
+	return scriptResults
+}
+
+// runBenchmark runs the same jobs once per parallelism level and prints the
+// wall-clock time and speedup over the first level (-benchmark). With
+// -replay the recordings are played back with their original timing, so
+// the curve can be measured without touching live APIs.
+func runBenchmark(ctx context.Context, levels string, base *Runner) {
+	var parallels []int
+	for _, field := range splitList(levels) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "invalid -benchmark level %q\n", field)
+			os.Exit(2)
+		}
+		parallels = append(parallels, n)
+	}
+	if base.Options.ReplayDir != "" {
+		base.Options.ReplayTiming = true
+	} else {
+		slog.Warn("benchmarking against live scripts; record fixtures with -record and pass -replay to avoid that")
+	}
+
+	out, errOut := console, scriptStderr
+	console, scriptStderr = io.Discard, io.Discard
+	defer func() { console, scriptStderr = out, errOut }()
+
+	fmt.Fprintf(out, "\nBenchmark of %d scripts:\n", len(base.Jobs))
+	fmt.Fprintf(out, "  %-9s %14s %9s %8s\n", "PARALLEL", "WALL TIME", "SPEEDUP", "FAILED")
+	var first time.Duration
+	for _, n := range parallels {
+		if ctx.Err() != nil {
+			break
+		}
+		base.Parallel = n
+		start := time.Now()
+		results := base.Run(ctx)
+		wall := time.Since(start)
+		if first == 0 {
+			first = wall
+		}
+		failed := 0
+		for _, res := range results {
+			if !res.Success {
+				failed++
+			}
+		}
+		fmt.Fprintf(out, "  %-9d %14v %8.2fx %8d\n", n, wall.Round(time.Millisecond), float64(first)/float64(wall), failed)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+const cancelFilePoll = time.Second
+
+// watchCancelFile returns a context that is cancelled, like on SIGTERM, as
+// soon as path exists. It gives orchestrators without signal access a way to
+// stop a run.
+func watchCancelFile(parent context.Context, path string) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		ticker := time.NewTicker(cancelFilePoll)
+		defer ticker.Stop()
+		for {
+			if fileExists(path) {
+				slog.Warn("cancel file found; shutting down", "path", path)
+				fmt.Fprintf(console, "\n⚠ Cancel file %s found; stopping run\n", path)
+				cancel(fmt.Errorf("cancel file %s appeared", path))
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ctx
+}
+
+// watchScript reruns one exchange every time its script file changes, until
+// ctx is cancelled. Changes are detected by polling the file's size and
+// modification time, which needs no platform-specific notification API.
+func watchScript(ctx context.Context, scriptDir string, ex Exchange, opts runOptions) {
+	scriptPath := filepath.Join(scriptDir, ex.Script)
+	var lastMod time.Time
+	var lastSize int64 = -1
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	fmt.Fprintf(console, "👀 Watching %s (Ctrl+C to stop)\n", scriptPath)
+	for {
+		info, err := os.Stat(scriptPath)
+		if err != nil {
+			slog.Warn("cannot stat watched script", "script", scriptPath, "error", err)
+		} else if !info.ModTime().Equal(lastMod) || info.Size() != lastSize {
+			lastMod, lastSize = info.ModTime(), info.Size()
+			fmt.Fprintln(console)
+			runScript(ctx, scriptDir, ex, opts, 1, 1)
+			fmt.Fprintf(console, "\n👀 Waiting for changes to %s...\n", ex.Script)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeCheck is one line of the -probe breakdown; warn marks a finding that
+// doesn't fail the probe.
+type probeCheck struct {
+	name   string
+	ok     bool
+	warn   bool
+	detail string
+}
+
+// probeExchange runs one exchange and reports each check its result went
+// through separately (-probe), for whoever is developing its scraper. It
+// returns whether all of them passed.
+func probeExchange(ctx context.Context, scriptDir string, ex Exchange, opts runOptions) bool {
+	var checks []probeCheck
+	add := func(name string, ok bool, format string, args ...any) {
+		checks = append(checks, probeCheck{name: name, ok: ok, detail: fmt.Sprintf(format, args...)})
+	}
+
+	scriptPath := filepath.Join(scriptDir, ex.Script)
+	if _, err := os.Stat(scriptPath); err != nil {
+		add("script", false, "%v", err)
+		printProbe(ex, checks)
+		return false
+	}
+	add("script", true, "%s", scriptPath)
+
+	result := runScript(ctx, scriptDir, ex, opts, 1, 1)
+	successCodes := opts.SuccessCodes
+	if ex.SuccessCodes != nil {
+		successCodes = ex.SuccessCodes
+	}
+	// The same mapping runScript applies, so -probe agrees with a real run.
+	switch status := exitStatus(ex, result.exitCode, successCodes); {
+	case result.exitCode < 0:
+		add("exit status", false, "did not exit normally: %v", result.Error)
+	case status == "":
+		add("exit status", result.exitCode == 0, "%d after %v", result.exitCode, result.Duration)
+	default:
+		add("exit status", status == "success" || status == "skip", "%d, mapped to %s, after %v", result.exitCode, status, result.Duration)
+	}
+
+	outputDir := filepath.Join(scriptDir, ex.OutputDir())
+	symbols, err := readSymbols(outputDir, opts.OutputFormat)
+	switch {
+	case err != nil:
+		add("output", false, "no output directory %s", outputDir)
+	case len(symbols) == 0:
+		add("output", false, "no .%s data files in %s", opts.OutputFormat, outputDir)
+	default:
+		add("output", true, "%d .%s data files in %s", len(symbols), opts.OutputFormat, outputDir)
+	}
+	if ex.MinSymbols > 0 {
+		add("min symbols", len(symbols) >= ex.MinSymbols, "%d symbols, need %d", len(symbols), ex.MinSymbols)
+	}
+
+	switch ex.Format {
+	case "remove_dash":
+		dashed := slices.IndexFunc(symbols, func(s string) bool { return strings.Contains(s, "-") })
+		if dashed >= 0 {
+			add("symbol format", false, "remove_dash, but %s has a dash", symbols[dashed])
+		} else {
+			add("symbol format", true, "remove_dash")
+		}
+	case "":
+		checks = append(checks, probeCheck{name: "symbol format", ok: true, warn: true, detail: "no format declared"})
+	default:
+		add("symbol format", true, "%s", ex.Format)
+	}
+	if len(symbols) > 0 {
+		add("sample", true, "%s", strings.Join(symbols[:min(len(symbols), 5)], ", "))
+	}
+
+	if opts.OutputFormat == "json" {
+		if err := validateOutput(outputDir, "json", ""); err != nil {
+			add("valid JSON", false, "%v", err)
+		} else {
+			add("valid JSON", true, "every data file parses")
+		}
+	}
+	if ex.Schema != "" {
+		if opts.OutputFormat != "json" {
+			add("schema", false, "%s only applies to -output-format json", ex.Schema)
+		} else if err := validateOutput(outputDir, "json", schemaPath(scriptDir, ex)); err != nil {
+			add("schema", false, "%v", err)
+		} else {
+			add("schema", true, "every data file matches %s", ex.Schema)
+		}
+	}
+
+	overall := "success"
+	if result.Error != nil {
+		overall = result.Error.Error()
+	}
+	add("overall result", result.Success, "%s", overall)
+	printProbe(ex, checks)
+	return !slices.ContainsFunc(checks, func(c probeCheck) bool { return !c.ok })
+}
+
+func printProbe(ex Exchange, checks []probeCheck) {
+	fmt.Fprintf(console, "\n🔬 Probe of %s:\n", ex.Name)
+	fmt.Fprintln(console, strings.Repeat("-", 60))
+	for _, c := range checks {
+		mark := "✓"
+		switch {
+		case !c.ok:
+			mark = "✗"
+		case c.warn:
+			mark = "⚠"
+		}
+		fmt.Fprintf(console, "%s %-15s %s\n", mark, c.name, c.detail)
+	}
+}
+
+// newLogger builds the diagnostics logger. Human-facing progress output stays
+// on stdout; these structured events go to w so they can be filtered or
+// shipped elsewhere.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q: want text or json", format)
+	}
+}
+
+// readNameList reads one exchange name per line; blank lines and anything
+// after a '#' are ignored.
+func readNameList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s lists no exchanges", path)
+	}
+	return names, nil
+}
+
+// splitList parses a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// autoParallelPerCPU is how many scripts -parallel auto runs per CPU. The
+// scripts mostly wait on exchange APIs, so more than one per core pays off.
+const autoParallelPerCPU = 2
+
+// parallelFlag is -parallel: a count, or "auto" for autoParallelPerCPU
+// scripts per CPU, or "auto:F" for F per CPU (e.g. auto:0.5 on a busy host).
+type parallelFlag struct{ n *int }
+
+func (p parallelFlag) String() string {
+	if p.n == nil {
+		return ""
+	}
+	return strconv.Itoa(*p.n)
+}
+
+func (p parallelFlag) Set(s string) error {
+	factor := float64(autoParallelPerCPU)
+	rest, auto := strings.CutPrefix(s, "auto")
+	if !auto {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New(`want a number, "auto" or "auto:F"`)
+		}
+		*p.n = n
+		return nil
+	}
+	if rest != "" {
+		f, err := strconv.ParseFloat(strings.TrimPrefix(rest, ":"), 64)
+		if err != nil || !strings.HasPrefix(rest, ":") || f <= 0 {
+			return errors.New(`want "auto:F" with a positive factor F`)
+		}
+		factor = f
+	}
+	*p.n = max(int(float64(runtime.NumCPU())*factor), 1)
+	return nil
+}
+
+func main() {
+	runLimitHelper()
+	failOnEmptyTotal := flag.Bool("fail-on-empty-total", false, "exit non-zero if all exchanges together produced zero symbols")
+	logLevel := flag.String("log-level", "warn", "minimum level of diagnostic logs on stderr: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of diagnostic logs: text or json")
+	fromFile := flag.String("from-file", "", "run exactly the exchanges listed in this file (one per line, # comments), in order, regardless of enabled flags")
+	filter := flag.String("filter", "", "comma-separated exchanges to run; all enabled exchanges when empty")
+	skip := flag.String("skip", "", "comma-separated exchanges to exclude, applied after -filter")
+	historyPath := flag.String("history", ".run_history.json", "history file, relative to script-dir unless absolute; empty disables history")
+	historyLimit := flag.Int("history-limit", 100, "keep only the newest N runs in the history file; 0 keeps all")
+	reportPath := flag.String("report", "", "write a JSON report of the run to this file")
+	timeout := flag.Duration("timeout", 0, "maximum run time per script (e.g. 30m); 0 means no limit")
+	killGrace := flag.Duration("kill-grace", 5*time.Second, "how long a terminated script gets after SIGTERM before SIGKILL")
+	configPath := flag.String("config", "", "JSON config whose exchange entries override the built-in roster by name")
+	watch := flag.String("watch", "", "rerun this one script (file or exchange name) whenever it changes, until interrupted")
+	stream := flag.Bool("stream", false, "write each result as one JSON line to stdout as soon as it finishes; human output moves to stderr")
+	title := flag.String("title", "", "label for this run, shown in a banner and included in reports")
+	logDir := flag.String("log-dir", "", "write each script's complete output to <dir>/<exchange>.log")
+	interpreter := flag.String("interpreter", defaultInterpreter, "interpreter used to run the scripts")
+	venv := flag.String("venv", "", "run scripts with this virtualenv's python, activated as bin/activate would")
+	allowMissingInterpreter := flag.Bool("allow-missing-interpreter", false, "record every script as failed instead of aborting when the interpreter is missing")
+	showStats := flag.Bool("stats", false, "add p50/p90/p99 script duration statistics to the summary and report")
+	maxMsPerSymbol := flag.Float64("max-ms-per-symbol", 0, "flag exchanges whose run time per collected symbol exceeds this many milliseconds; 0 disables")
+	outputFormat := flag.String("output-format", "csv", "data file format requested from every script via OUTPUT_FORMAT: csv, json or txt")
+	proxy := flag.String("proxy", "", "proxy URL exported to scripts as HTTP_PROXY/HTTPS_PROXY; defaults to the config's proxy, else the inherited environment")
+	noProxy := flag.String("no-proxy", "", "hosts exported to scripts as NO_PROXY; defaults to the config's noProxy")
+	retries := flag.Int("retries", 0, "retry a failed script up to N more times")
+	retryDelay := flag.Duration("retry-delay", 2*time.Second, "base delay before the first retry, doubled for each further retry")
+	retryJitter := flag.String("retry-jitter", "none", "randomise retry delays: none, full or decorrelated")
+	retrySeed := flag.Uint64("retry-seed", 0, "seed for -retry-jitter; a fixed non-zero seed gives reproducible delays")
+	baselinePath := flag.String("baseline", "", "compare durations and symbol counts with this earlier -report file and fail on regressions")
+	baselineSlowdown := flag.Float64("baseline-max-slowdown", 20, "with -baseline, percent an exchange may be slower before it counts as a regression")
+	baselineSymbolDrop := flag.Float64("baseline-max-symbol-drop", 10, "with -baseline, percent of symbols an exchange may lose before it counts as a regression")
+	countOnly := flag.Bool("count-only", false, "run scripts with COUNT_ONLY=1 and print just a table of symbol counts per exchange")
+	discover := flag.Bool("discover", false, "build the roster from the scripts in script-dir, using the metadata of those that support --describe")
+	cancelFile := flag.String("cancel-file", "", "stop the run gracefully, as on SIGTERM, once this file exists")
+	parallel := new(int)
+	*parallel = 1
+	flag.Var(parallelFlag{parallel}, "parallel", "run up to this many scripts at once, their output interleaving line by line; \"auto\" runs 2 per CPU, \"auto:F\" F per CPU")
+	maxPerHost := flag.Int("max-concurrency-per-host", 0, "with -parallel, run at most this many scripts sharing a config host group at once (0 = no limit)")
+	webhook := flag.String("webhook", "", "POST a JSON run summary to this URL when the run finishes")
+	notifyOnChange := flag.Bool("notify-on-change", false, "with -webhook, only notify when the set of failing exchanges differs from the previous history run")
+	printEnvFlag := flag.Bool("print-env", false, "print each script's environment, with secret values masked, before running it")
+	validateCfg := flag.Bool("validate-config", false, "check the -config file for unknown fields, bad values, duplicate names and missing scripts, then exit")
+	between := flag.Duration("between", 0, "in sequential mode, pause this long between scripts (shown separately from active time)")
+	recordDir := flag.String("record", "", "save each script's stdout, stderr and exit status to this directory")
+	replayDir := flag.String("replay", "", "play back recordings from -record instead of running scripts")
+	replayTiming := flag.Bool("replay-timing", false, "with -replay, reproduce the recorded timings instead of replaying instantly")
+	tag := flag.String("tag", "", "only run exchanges whose config tags match this expression, e.g. \"us AND (spot OR NOT high-volume)\"")
+	quietSuccess := flag.Bool("quiet-success", false, "print only a one-line OK when everything succeeds; otherwise print the full output and summary")
+	successCodes := flag.String("success-codes", "0", "comma-separated exit codes that count as success (0 always does); config successCodes overrides per exchange")
+	stallTimeout := flag.Duration("stall-timeout", 0, "kill a script that produces no output for this long (0 = never)")
+	mergePath := flag.String("merge", "", "after the run, write every successful exchange's symbols to this file as exchange,symbol (CSV, or NDJSON for .ndjson/.jsonl); scripts write only their own output directory and the runner merges them")
+	maxRPS := flag.Float64("max-rps", 0, "hold back script launches while the requests scripts report via ::stat requests=N exceed this many per second in total (0 = no cap)")
+	compare := flag.Bool("compare", false, "compare two -report files given as arguments (old.json new.json) and exit")
+	repeatEach := flag.Int("repeat-each", 1, "run every selected exchange this many times and report its pass rate and duration spread")
+	maxTotal := flag.Duration("max-total", 0, "stop launching scripts and terminate running ones once the whole run has taken this long (0 = no limit)")
+	failThreshold := flag.Float64("fail-threshold-percent", 0, "exit non-zero only if more than this percentage of scripts failed")
+	compressLogs := flag.Bool("compress-logs", false, "gzip the -log-dir files as <exchange>.log.gz")
+	manifestPath := flag.String("manifest", "", "JSON file of expected script SHA-256 checksums ({\"scripts\": {\"bybit.py\": \"...\"}}); warn about scripts that differ")
+	strict := flag.Bool("strict", false, "with -manifest, refuse to run when any script differs from the manifest")
+	initConfig := flag.Bool("init", false, "write a starter -config (default run_all.json in script-dir) listing every script, disabled, then exit")
+	force := flag.Bool("force", false, "with -init, overwrite an existing config file")
+	adaptiveTimeout := flag.Float64("adaptive-timeout", 0, "set each exchange's timeout to this multiple of its median successful duration in the history (0 = off; exchanges without history use -timeout)")
+	adaptiveMin := flag.Duration("adaptive-timeout-min", 30*time.Second, "lower bound for -adaptive-timeout")
+	adaptiveMax := flag.Duration("adaptive-timeout-max", time.Hour, "upper bound for -adaptive-timeout")
+	noSummary := flag.Bool("no-summary", false, "print no banners or final summary, e.g. when a tool consumes -stream output")
+	atomicOutput := flag.Bool("atomic-output", false, "have scripts write to a staging directory (OUTPUT_DIR) that replaces the output directory only after a successful, validated run")
+	benchmark := flag.String("benchmark", "", "run the selected scripts once per comma-separated -parallel level (e.g. 1,2,4,8) and print wall time and speedup; combine with -replay to spare live APIs")
+	keepPartial := flag.Bool("keep-partial", false, "when a script times out or stalls after writing some output, keep it and record the exchange as partial rather than failed")
+	repeat := flag.Duration("repeat", 0, "keep running: start the selection again this long after each run finishes, until interrupted (0 = run once)")
+	httpAddr := flag.String("http", "", "with -repeat, serve the latest run's summary at /status and a liveness check at /healthz on this address, e.g. :8080")
+	strictFormat := flag.Bool("strict-format", false, "fail a script whose output contradicts its declared symbol format (e.g. dashed symbols under remove_dash) instead of only warning")
+	failuresDir := flag.String("failures-dir", "", "write each failed exchange's error, exit code, category, traceback and output tail to <dir>/<exchange>.txt; the directory is emptied at the start of each run")
+	precision := flag.Duration("duration-precision", 0, "round durations in the console output to this, e.g. 100ms or 1s; reports and history keep full precision (0 = full)")
+	batchSize := flag.Int("batch-size", 0, "run the selected exchanges in successive batches of N, each starting once the previous one has finished; within a batch up to -parallel run at once, all N unless -parallel is given (0 = no batches)")
+	batchPause := flag.Duration("batch-pause", 0, "with -batch-size, wait this long between batches, e.g. for an upstream rate limit window to reset")
+	templatePath := flag.String("template", "", "render the summary with this text/template file instead of the built-in one. Fields: .Title .Started .Duration .ScriptsCommit .RunnerVersion .Results .Successful .Failed .Partial .Skipped .Symbols .ExitCode; helpers: duration, seconds, status, only, count, pad, join, percent")
+	failOnNewFailure := flag.String("fail-on-new-failure", "", "gate on this earlier -report file instead of -fail-threshold-percent: exit non-zero only for exchanges failing now that passed there (or are new), reporting pre-existing failures without failing")
+	liveLines := flag.Int("live-lines", 0, "echo only the first N lines of each script's output live, then its last N when it ends; logs and captured output keep everything (0 = echo all)")
+	teePath := flag.String("tee", "", "also append the whole console session (banners, progress, script output, summary) to this file")
+	teeTimestamps := flag.Bool("tee-timestamps", false, "with -tee, prefix every line in the file with the time it was written")
+	sqlitePath := flag.String("sqlite", "", "append each run to this SQLite database, in tables runs and results (created if missing); needs the sqlite3 command")
+	estimate := flag.Bool("estimate", false, "print the expected duration of each selected exchange, from history medians, and the predicted wall clock at the chosen -parallel, then exit without running anything")
+	estimateDefault := flag.Duration("estimate-default", time.Minute, "with -estimate, the duration assumed for exchanges without successful history runs")
+	require := flag.String("require", "", "comma-separated exchanges that fail the run if they fail, regardless of -fail-threshold-percent; adds to the config's requiredExchanges")
+	printCommandFlag := flag.Bool("print-command", false, "print each script's exact command line and working directory, with secrets masked, before running it")
+	quarantineAfter := flag.Int(
+		"quarantine-after", 0, "skip exchanges whose last N recorded runs all failed, reporting them as quarantined (0 disables; needs history)")
+	unquarantine := flag.Bool("unquarantine", false, "with -quarantine-after, run quarantined exchanges this time; a success releases them")
+	otelEndpoint := flag.String("otel-endpoint", "", "after each run, export it as an OpenTelemetry trace (a run span with a span per exchange) via OTLP/HTTP JSON to this collector, e.g. http://localhost:4318")
+	interactiveStdin := flag.Bool("interactive-stdin", false, "connect scripts to this terminal's stdin so they can prompt; by default they read /dev/null and fail fast instead of hanging (sequential runs only)")
+	symbolConflicts := flag.Bool("symbol-conflicts", false, "after the run, list symbols that exchanges spell differently (BTC-USDT vs BTCUSDT) even after normalizing them (format and config normalize rules)")
+	probe := flag.String("probe", "", "run just this exchange, enabled or not, and print a pass/fail breakdown of every check on its output (exit status, symbols, format, JSON, schema)")
+	runsDir := flag.String("runs-dir", "", "archive each run under <dir>/<run-id>/: a copy of every exchange's output directory, the logs (unless -log-dir is set) and report.json")
+	latestLink := flag.Bool("latest-symlink", false, "with -runs-dir, point <dir>/latest at the newest run")
+	keepRuns := flag.Int("keep-runs", 0, "with -runs-dir, delete all but the newest N run directories (0 keeps all)")
+	explainFailures := flag.Bool("explain-failures", false, "after the summary, suggest a likely cause for each failure (auth, rate limit, network, timeout, ...) from a built-in rule table")
+	dockerImage := flag.String(
+		"docker", "", "run every script in this container image via docker run, with script-dir mounted at /scripts; config image overrides it per exchange")
+	softDeadline := flag.Duration("soft-deadline", 0, "once the run has taken this long, start no more scripts but let running ones finish; the rest are recorded as skipped (0 = none)")
+	maskSecrets := flag.Bool(
+		"mask-secrets", false, "replace the values of secret-looking environment variables and the config's maskPatterns with **** in script output, logs, recordings and reports")
+	fetchSource := flag.String(
+		"fetch-scripts", "", "before running, fetch the scripts from this git URL or .tar.gz/.zip archive (URL or path) into a cache keyed by commit, and run them from there")
+	cpuset := flag.String("cpuset", "", "pin every script to these CPUs (e.g. 0-3 or 0,2) via taskset, for steadier timings; warns and runs unpinned where unsupported")
+	outputBuffer := flag.String(
+		"output-buffer", "", "buffer console output and script stderr in chunks of this size (e.g. 64K), flushed after each script; empty writes through unbuffered. Cuts the runner's cost per line of line-at-a-time output about tenfold (BenchmarkOutputBuffer); scripts whose output already arrives in large chunks see no difference")
+	limit := flag.Int(
+		"limit", 0, "run only the first N selected exchanges, in run order, and skip the rest (0 = no limit)")
+	minRun := flag.Int(
+		"min-run", 0, "fail before running anything if fewer than this many exchanges are selected to run, e.g. after a -filter typo")
+	maxSkips := flag.Int("max-skips", -1, "fail before running anything if more than this many roster exchanges would be skipped (-1 = no limit)")
+	explain := flag.Bool("explain", false, "print which exchanges would run, in what order and why others are skipped, then exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [script-dir]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *compare {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: -compare old.json new.json")
+			os.Exit(2)
+		}
+		reports := make([]*report, 2)
+		for i := range reports {
+			rep, err := loadReport(flag.Arg(i))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			reports[i] = rep
+		}
+		compareReports(os.Stdout, flag.Arg(0), flag.Arg(1), reports[0], reports[1])
+		return
+	}
+
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *title != "" {
+		logger = logger.With("title", *title)
+	}
+	slog.SetDefault(logger)
+
+	if *countOnly {
+		// Only the final table is of interest.
+		console = io.Discard
+		scriptStderr = io.Discard
+	}
+	var events *json.Encoder
+	if *stream {
+		console = os.Stderr
+		events = json.NewEncoder(os.Stdout)
+	}
+	if *outputBuffer != "" {
+		size, err := parseSize(*outputBuffer)
+		if err != nil || size < 0 || size > math.MaxInt32 {
+			fmt.Fprintf(os.Stderr, "invalid -output-buffer %q: want a size such as 64K\n", *outputBuffer)
+			os.Exit(2)
+		}
+		if size > 0 {
+			console = newBufferedOutput(console, int(size))
+			if scriptStderr == os.Stderr {
+				scriptStderr = newBufferedOutput(scriptStderr, int(size))
+			}
+			// Panics still unwind through main; os.Exit calls flush first.
+			defer flushOutput()
+		}
+	}
+	// -tee mirrors what reaches the terminal, so under -quiet-success
+	// the transcript is as quiet.
+	if *teePath != "" {
+		f, err := os.OpenFile(*teePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-tee: %v\n", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		tee := &sessionLog{f: f, timestamps: *teeTimestamps}
+		console = io.MultiWriter(console, tee)
+		scriptStderr = io.MultiWriter(scriptStderr, tee)
+	}
+	// -quiet-success holds back everything, script stderr included, until
+	// the outcome is known.
+	var quietOut io.Writer
+	var quietBuf *tailBuffer
+	if *quietSuccess {
+		quietOut, quietBuf = console, &tailBuffer{max: quietOutputSize}
+		console, scriptStderr = quietBuf, quietBuf
+	}
+
+	scriptDir := "."
+	if flag.NArg() > 0 {
+		scriptDir = flag.Arg(0)
+	}
+	// With -fetch-scripts, script-dir is a subdirectory of the fetched tree.
+	if *fetchSource != "" {
+		fetched, err := fetchScripts(context.Background(), *fetchSource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-fetch-scripts: %v\n", err)
+			os.Exit(2)
+		}
+		scriptDir = filepath.Join(fetched, scriptDir)
+		fmt.Fprintf(console, "📦 Running scripts fetched from %s in %s\n", *fetchSource, scriptDir)
+	}
+
+	if *validateCfg {
+		if *configPath == "" {
+			fmt.Fprintln(os.Stderr, "-validate-config needs -config")
+			os.Exit(2)
+		}
+		problems := validateConfig(*configPath, scriptDir)
+		for _, p := range problems {
+			fmt.Printf("✗ %s\n", p)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%s: %d problem(s)\n", *configPath, len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("✓ %s is valid\n", *configPath)
+		return
+	}
+
+	if *initConfig {
+		path := cmp.Or(*configPath, filepath.Join(scriptDir, defaultInitConfig))
+		if fileExists(path) && !*force {
+			fmt.Fprintf(os.Stderr, "%s already exists; use -force to overwrite it\n", path)
+			os.Exit(2)
+		}
+		cfg := scaffoldConfig(*interpreter, scriptDir)
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(path, append(data, '\n'))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Wrote %s with %d exchanges, all disabled; enable the ones to run\n", path, len(cfg.Exchanges))
+		return
+	}
+
+	cfg := &config{}
+	if *configPath != "" {
+		cfg, err = loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	runOpts := runOptions{
+		Interpreter:  *interpreter,
+		OutputFormat: *outputFormat,
+		CountOnly:    *countOnly,
+		PrintEnv:     *printEnvFlag,
+		PrintCommand: *printCommandFlag,
+		StrictFormat: *strictFormat,
+		LiveLines:    *liveLines,
+		Proxy:        cmp.Or(*proxy, cfg.Proxy),
+		NoProxy:      cmp.Or(*noProxy, cfg.NoProxy),
+		Timeout:      *timeout,
+		KillGrace:    *killGrace,
+		StallTimeout: *stallTimeout,
+		CompressLogs: *compressLogs,
+		AtomicOutput: *atomicOutput,
+		KeepPartial:  *keepPartial,
+		MaskSecrets:  *maskSecrets,
+		LogDir:       *logDir,
+	}
+	runOpts.MaskPatterns, err = compileMaskPatterns(cfg.MaskPatterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(2)
+	}
+	if !slices.Contains(outputFormats, runOpts.OutputFormat) {
+		fmt.Fprintf(os.Stderr, "invalid -output-format %q: want one of %s\n", runOpts.OutputFormat, strings.Join(outputFormats, ", "))
+		os.Exit(2)
+	}
+	if *venv != "" {
+		abs, err := filepath.Abs(*venv)
+		if err == nil {
+			runOpts.Interpreter, err = venvInterpreter(abs)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		runOpts.Venv = abs
+	}
+
+	// Roster precedence: built-in table, then what the scripts describe
+	// about themselves, then their "# runner:" annotations, then the
+	// config file.
+	exchanges := defaultExchanges
+	if *discover {
+		discovered := discoverExchanges(runOpts.Interpreter, scriptDir)
+		exchanges = mergeDiscovered(exchanges, discovered)
+	}
+	exchanges = applyAnnotations(scriptDir, exchanges)
+
+	exchanges, err = mergeExchanges(exchanges, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	required := append(slices.Clone(cfg.RequiredExchanges), splitList(*require)...)
+	warnUnmatched("-require", required, exchanges)
+
+	planOpts := planOptions{
+		Filter: splitList(*filter),
+		Skip:   splitList(*skip),
+	}
+	for _, entry := range cfg.Exchanges {
+		planOpts.ConfigOrder = append(planOpts.ConfigOrder, entry.Name)
+	}
+	if *tag != "" {
+		planOpts.Tag, err = parseTagExpr(*tag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *fromFile != "" {
+		planOpts.Listed, err = readNameList(*fromFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	var hist *history
+	if *historyPath != "" {
+		if !filepath.IsAbs(*historyPath) {
+			*historyPath = filepath.Join(scriptDir, *historyPath)
+		}
+		hist, err = loadHistory(*historyPath)
+		if err != nil {
+			slog.Warn("ignoring unreadable history", "path", *historyPath, "error", err)
+			hist = &history{}
+		}
+	}
+	plan := buildPlan(scriptDir, exchanges, planOpts)
+	if *unquarantine && *quarantineAfter == 0 {
+		fmt.Fprintln(os.Stderr, "-unquarantine needs -quarantine-after")
+		os.Exit(2)
+	}
+	quarantined := map[string]int{}
+	// unquarantined is the plan before quarantine, applied again from the
+	// grown history before every later -repeat run.
+	var unquarantined []planEntry
+	release := *unquarantine
+	if *quarantineAfter > 0 {
+		if hist == nil {
+			fmt.Fprintln(os.Stderr, "-quarantine-after needs history (-history must not be empty)")
+			os.Exit(2)
+		}
+		unquarantined = slices.Clone(plan)
+		quarantined = quarantine(plan, hist, *quarantineAfter, release)
+	}
+	runOpts.SuccessCodes, err = parseExitCodes(*successCodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -success-codes: %v\n", err)
+		os.Exit(2)
+	}
+	if *recordDir != "" && *replayDir != "" {
+		fmt.Fprintln(os.Stderr, "-record and -replay are mutually exclusive")
+		os.Exit(2)
+	}
+	runOpts.RecordDir, runOpts.ReplayDir, runOpts.ReplayTiming = *recordDir, *replayDir, *replayTiming
+
+	runOpts.DockerImage = *dockerImage
+	if slices.ContainsFunc(plan, func(entry planEntry) bool { return entry.Run && entry.Image != "" }) || *dockerImage != "" {
+		if _, err := exec.LookPath("docker"); err != nil && *replayDir == "" {
+			fmt.Fprintf(os.Stderr, "-docker or a config image needs docker: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *sqlitePath != "" {
+		if _, err := exec.LookPath("sqlite3"); err != nil {
+			fmt.Fprintf(os.Stderr, "-sqlite needs the sqlite3 command: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if _, err := exec.LookPath(runOpts.Interpreter); err != nil && *replayDir == "" && *dockerImage == "" {
+		if !*allowMissingInterpreter {
+			fmt.Fprintf(os.Stderr, "interpreter %q not found: %v\n", runOpts.Interpreter, err)
+			os.Exit(2)
+		}
+		slog.Warn("interpreter not found; every script will be recorded as failed", "interpreter", runOpts.Interpreter, "error", err)
+	}
+
+	if !slices.Contains(retryJitters, *retryJitter) {
+		fmt.Fprintf(os.Stderr, "invalid -retry-jitter %q: want one of %s\n", *retryJitter, strings.Join(retryJitters, ", "))
+		os.Exit(2)
+	}
+	if *notifyOnChange && (*webhook == "" || *historyPath == "") {
+		fmt.Fprintln(os.Stderr, "-notify-on-change needs -webhook and history (-history must not be empty)")
+		os.Exit(2)
+	}
+	retry := newRetryPolicy(*retries, *retryDelay, *retryJitter, *retrySeed)
+
+	if *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "invalid -parallel %d: must be at least 1\n", *parallel)
+		os.Exit(2)
+	}
+	if *repeat < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -repeat %v: must not be negative\n", *repeat)
+		os.Exit(2)
+	}
+	if (*latestLink || *keepRuns > 0) && *runsDir == "" {
+		fmt.Fprintln(os.Stderr, "-latest-symlink and -keep-runs need -runs-dir")
+		os.Exit(2)
+	}
+	if *httpAddr != "" && *repeat == 0 {
+		fmt.Fprintln(os.Stderr, "-http needs -repeat")
+		os.Exit(2)
+	}
+	if *cpuset != "" {
+		if !cpuListPattern.MatchString(*cpuset) {
+			fmt.Fprintf(os.Stderr, "invalid -cpuset %q: want a CPU list such as 0-3 or 0,2,4-7\n", *cpuset)
+			os.Exit(2)
+		}
+		if err := checkCPUSet(*cpuset); err != nil {
+			slog.Warn("cannot pin scripts to CPUs; running them unpinned", "cpuset", *cpuset, "error", err)
+		} else {
+			runOpts.CPUSet = *cpuset
+		}
+	}
+	if *maxRPS > 0 {
+		runOpts.Budget = newRequestBudget(*maxRPS)
+	}
+	if *failuresDir != "" {
+		// The directory is emptied before every run; refuse the places
+		// that hold anything else worth keeping.
+		abs, _ := filepath.Abs(*failuresDir)
+		cwd, _ := os.Getwd()
+		scripts, _ := filepath.Abs(scriptDir)
+		if abs == cwd || abs == scripts || abs == filepath.Dir(abs) {
+			fmt.Fprintf(os.Stderr, "invalid -failures-dir %q: it is emptied on every run, use a dedicated directory\n", *failuresDir)
+			os.Exit(2)
+		}
+	}
+	if *precision < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -duration-precision %v: must not be negative\n", *precision)
+		os.Exit(2)
+	}
+	durationPrecision = *precision
+	if *batchSize < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -batch-size %d: must not be negative\n", *batchSize)
+		os.Exit(2)
+	}
+	if *batchPause != 0 && *batchSize == 0 {
+		fmt.Fprintln(os.Stderr, "-batch-pause needs -batch-size")
+		os.Exit(2)
+	}
+	if *batchSize > 0 {
+		// A batch runs all at once unless -parallel caps it.
+		parallelGiven := false
+		flag.Visit(func(f *flag.Flag) { parallelGiven = parallelGiven || f.Name == "parallel" })
+		if !parallelGiven {
+			*parallel = *batchSize
+		}
+	}
+	if *between > 0 && *parallel > 1 {
+		fmt.Fprintln(os.Stderr, "-between only applies to sequential runs; drop -parallel")
+		os.Exit(2)
+	}
+	if *liveLines < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -live-lines %d: must not be negative\n", *liveLines)
+		os.Exit(2)
+	}
+	if *interactiveStdin && *parallel > 1 {
+		fmt.Fprintln(os.Stderr, "-interactive-stdin only applies to sequential runs; drop -parallel")
+		os.Exit(2)
+	}
+	runOpts.InteractiveStdin = *interactiveStdin
+	var otlpURL string
+	if *otelEndpoint != "" {
+		otlpURL, err = otlpTracesURL(*otelEndpoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	var baseline *report
+	if *baselinePath != "" {
+		baseline, err = loadReport(*baselinePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	var tmpl *template.Template
+	if *templatePath != "" {
+		tmpl, err = loadTemplate(*templatePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	var failBase *report
+	if *failOnNewFailure != "" {
+		failBase, err = loadReport(*failOnNewFailure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *cancelFile != "" {
+		ctx = watchCancelFile(ctx, *cancelFile)
+	}
+	if *logDir != "" {
+		if err := os.MkdirAll(*logDir, 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if *probe != "" {
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Script == *probe || ex.Name == *probe })
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "-probe: unknown exchange %q\n", *probe)
+			os.Exit(2)
+		}
+		if !probeExchange(ctx, scriptDir, exchanges[i], runOpts) {
+			flushOutput()
+			os.Exit(1)
+		}
+		return
+	}
+	if *watch != "" {
+		i := slices.IndexFunc(exchanges, func(ex Exchange) bool { return ex.Script == *watch || ex.Name == *watch })
+		ex := Exchange{Name: strings.TrimSuffix(filepath.Base(*watch), ".py"), Script: *watch}
+		if i >= 0 {
+			ex = exchanges[i]
+		}
+		watchScript(ctx, scriptDir, ex, runOpts)
+		return
+	}
+
+	validScripts := []Exchange{}
+	for _, entry := range plan {
+		switch {
+		case entry.Run:
+			validScripts = append(validScripts, entry.Exchange)
+		case entry.Reason == "file not found":
+			slog.Warn("skipping exchange", "exchange", entry.Name, "script", entry.Script, "reason", entry.Reason)
+		default:
+			slog.Debug("skipping exchange", "exchange", entry.Name, "script", entry.Script, "reason", entry.Reason)
+		}
+	}
+
+	if *minRun > 0 || *maxSkips >= 0 {
+		skippedByReason := map[string]int{}
+		for _, entry := range plan {
+			if !entry.Run {
+				skippedByReason[entry.Reason]++
+			}
+		}
+		skipped := len(plan) - len(validScripts)
+		var tripped string
+		switch {
+		case len(validScripts) < *minRun:
+			tripped = fmt.Sprintf("only %d exchange(s) would run, -min-run is %d", len(validScripts), *minRun)
+		case *maxSkips >= 0 && skipped > *maxSkips:
+			tripped = fmt.Sprintf("%d exchange(s) would be skipped, -max-skips is %d", skipped, *maxSkips)
+		}
+		if tripped != "" {
+			fmt.Fprintf(os.Stderr, "✗ %s (%d to run, %d skipped)\n", tripped, len(validScripts), skipped)
+			for _, reason := range slices.Sorted(maps.Keys(skippedByReason)) {
+				fmt.Fprintf(os.Stderr, "  %3d %s\n", skippedByReason[reason], reason)
+			}
+			os.Exit(1)
+		}
+	}
+
+	validScripts, err = sortByDependencies(validScripts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Dependencies sort first, so the kept prefix never misses one.
+	limitSkipped := 0
+	if *limit > 0 && len(validScripts) > *limit {
+		limitSkipped = len(validScripts) - *limit
+		for _, ex := range validScripts[*limit:] {
+			slog.Debug("skipping exchange", "exchange", ex.Name, "script", ex.Script, "reason", "beyond -limit")
+		}
+		validScripts = validScripts[:*limit]
+	}
+
+	if shared := sharedOutputs(scriptDir, validScripts); len(shared) > 0 {
+		fmt.Fprintln(os.Stderr, "exchanges share an output directory; give each its own and let -merge aggregate them:")
+		for _, dir := range slices.Sorted(maps.Keys(shared)) {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", dir, strings.Join(shared[dir], ", "))
+		}
+		os.Exit(2)
+	}
+	if *mergePath != "" {
+		merged, _ := filepath.Abs(*mergePath)
+		for _, ex := range validScripts {
+			dir, _ := filepath.Abs(filepath.Join(scriptDir, ex.OutputDir()))
+			if rel, err := filepath.Rel(dir, merged); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+				fmt.Fprintf(os.Stderr, "-merge %s is inside %s's output directory %s\n", *mergePath, ex.Name, dir)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if *manifestPath != "" {
+		m, err := loadManifest(*manifestPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if problems := m.check(scriptDir, validScripts); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%d script(s) out of date with manifest %s:\n", len(problems), *manifestPath)
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  ✗ %s\n", p)
+			}
+			if *strict {
+				os.Exit(2)
+			}
+		}
+	}
+
+	if *adaptiveTimeout > 0 {
+		if hist == nil {
+			fmt.Fprintln(os.Stderr, "-adaptive-timeout needs history (-history must not be empty)")
+			os.Exit(2)
+		}
+		// Exchanges without successful history keep -timeout.
+		for i, ex := range validScripts {
+			median, ok := hist.medianDuration(ex.Name)
+			if !ok {
+				continue
+			}
+			t := time.Duration(float64(median) * *adaptiveTimeout)
+			validScripts[i].Timeout = min(max(t, *adaptiveMin), *adaptiveMax)
+			slog.Debug("adaptive timeout", "exchange", ex.Name, "median", median, "timeout", validScripts[i].Timeout)
+		}
+	}
+
+	if *explain {
+		printPlan(plan, validScripts, runOpts, *parallel, *maxPerHost, *between, *batchSize, *batchPause)
+		return
+	}
+	if *estimate {
+		jobs := validScripts
+		if *repeatEach > 1 {
+			jobs = nil
+			for _, ex := range validScripts {
+				for range *repeatEach {
+					jobs = append(jobs, ex)
+				}
+			}
+		}
+		printEstimate(jobs, hist, *estimateDefault, *parallel, *maxPerHost, *between, *batchSize, *batchPause)
+		return
+	}
+
+	// -no-summary leaves stdout/stderr to the scripts and -stream events.
+	banner := console
+	if *noSummary {
+		banner = io.Discard
+	}
+	if *title != "" {
+		fmt.Fprintln(banner, strings.Repeat("#", 61))
+		fmt.Fprintf(banner, "# %-57s #\n", *title)
+		fmt.Fprintf(banner, "# %-57s #\n", time.Now().Format("2006-01-02 15:04:05"))
+		fmt.Fprintln(banner, strings.Repeat("#", 61))
+	}
+	if *parallel > 1 {
+		fmt.Fprintf(banner, "Starting parallel execution of %d verified working Python scripts (%d at a time)...\n", len(validScripts), *parallel)
+	} else {
+		fmt.Fprintf(banner, "Starting sequential execution of %d verified working Python scripts...\n", len(validScripts))
+	}
+	// -repeat-each queues every exchange's runs back to back.
+	jobs := validScripts
+	if *repeatEach > 1 {
+		fmt.Fprintf(banner, "Each script runs %d times (-repeat-each)\n", *repeatEach)
+		jobs = nil
+		for _, ex := range validScripts {
+			for range *repeatEach {
+				jobs = append(jobs, ex)
+			}
+		}
+	}
+	fmt.Fprintln(banner, "="+strings.Repeat("=", 60))
+
+	if *benchmark != "" {
+		runBenchmark(ctx, *benchmark, &Runner{
+			ScriptDir:  scriptDir,
+			Jobs:       jobs,
+			Options:    runOpts,
+			Retry:      retry,
+			MaxPerHost: *maxPerHost,
+			CancelFile: *cancelFile,
+		})
+		return
+	}
+
+	runner := &Runner{
+		ScriptDir:    scriptDir,
+		Jobs:         jobs,
+		Options:      runOpts,
+		Retry:        retry,
+		Parallel:     *parallel,
+		MaxPerHost:   *maxPerHost,
+		Between:      *between,
+		CancelFile:   *cancelFile,
+		SoftDeadline: *softDeadline,
+		BatchSize:    *batchSize,
+		BatchPause:   *batchPause,
+		OnResult: func(result *ScriptResult) {
+			var prev historyResult
+			ok := false
+			if hist != nil {
+				prev, ok = hist.lastResult(result.Name)
+			}
+			if ok && result.Checksum != "" && prev.Checksum != "" {
+				if prev.Checksum == result.Checksum {
+					result.Change = "unchanged"
+				} else {
+					result.Change = "changed"
+				}
+			}
+			// Runs recorded before formats were kept have none.
+			if ok && prev.Format != "" && result.Format != "" && prev.Format != result.Format {
+				result.PreviousFormat = prev.Format
+			}
+
+			if events != nil {
+				if err := events.Encode(result); err != nil {
+					slog.Error("failed to stream result", "exchange", result.Name, "error", err)
+				}
+			}
+		},
+	}
+	// runOnce runs the selection and prints its summary; -repeat calls it
+	// again every interval.
+	// SIGUSR1 prints a progress snapshot without disturbing the run; it
+	// goes to stderr since stdout may be buffered or machine-readable.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			runner.WriteProgress(os.Stderr)
+		}
+	}()
+
+	first := true
+	runOnce := func() (report, int) {
+		// The summary below silences console for -no-summary and
+		// -template; the next -repeat run needs it back.
+		defer func(out io.Writer) { console = out }(console)
+		if unquarantined != nil && !first {
+			// Exchanges that reached -quarantine-after during the session
+			// sit out from now on; -unquarantine releases only the first run.
+			release = false
+			quarantined = quarantine(slices.Clone(unquarantined), hist, *quarantineAfter, release)
+			runner.Jobs = slices.DeleteFunc(slices.Clone(jobs), func(ex Exchange) bool {
+				_, ok := quarantined[ex.Name]
+				return ok
+			})
+		}
+		first = false
+		var runDir string
+		if *runsDir != "" {
+			dir, err := newRunDir(*runsDir, time.Now())
+			if err != nil {
+				slog.Error("cannot create run directory", "base", *runsDir, "error", err)
+				return report{}, 2
+			}
+			runDir = dir
+			runner.Options.LogDir = cmp.Or(*logDir, filepath.Join(runDir, "logs"))
+			if err := os.MkdirAll(runner.Options.LogDir, 0o755); err != nil {
+				slog.Error("cannot create log directory", "path", runner.Options.LogDir, "error", err)
+				return report{}, 2
+			}
+			fmt.Fprintf(banner, "📁 Run directory: %s\n", runDir)
+		}
+
+		runCtx := ctx
+		if *maxTotal > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeoutCause(ctx, *maxTotal, fmt.Errorf("-max-total of %v reached", *maxTotal))
+			defer cancel()
+		}
+
+		if *failuresDir != "" {
+			if err := cleanFailuresDir(*failuresDir); err != nil {
+				slog.Error("cannot clean failures directory", "path", *failuresDir, "error", err)
+				return report{}, 2
+			}
+		}
+
+		runner.Paused = 0
+		startTime := time.Now()
+		scriptResults := runner.Run(runCtx)
+		paused := runner.Paused
+		totalDuration := time.Since(startTime)
+		// Read per run, since -repeat may outlive a checkout.
+		commit := scriptsCommit(scriptDir)
+		rep := report{
+			Title: *title, Started: startTime, Duration: totalDuration,
+			ScriptsCommit: commit, RunnerVersion: runnerVersion(),
+			Results: scriptResults, Quarantined: quarantined,
+		}
+		if *showStats {
+			rep.DurationStats = computeDurationStats(scriptResults)
+		}
+		if runDir != "" {
+			archiveRun(runDir, scriptDir, validScripts, rep)
+			if *latestLink {
+				if err := updateLatestLink(*runsDir, runDir); err != nil {
+					slog.Error("failed to update latest link", "base", *runsDir, "error", err)
+				}
+			}
+			if *keepRuns > 0 {
+				pruneRuns(*runsDir, *keepRuns)
+			}
+		}
+
+		if *countOnly {
+			printCounts(os.Stdout, scriptResults)
+			for _, result := range scriptResults {
+				if !result.Success {
+					return rep, 1
+				}
+			}
+			return rep, 0
+		}
+
+		thisRun := newHistoryRun(startTime, totalDuration, scriptResults)
+		thisRun.ScriptsCommit, thisRun.RunnerVersion = commit, rep.RunnerVersion
+		if otlpURL != "" {
+			if err := exportTrace(otlpURL, buildTrace(*title, startTime, totalDuration, scriptResults)); err != nil {
+				slog.Error("failed to export trace", "endpoint", otlpURL, "error", err)
+			}
+		}
+		if *webhook != "" {
+			failing := thisRun.failing()
+			payload := webhookPayload{
+				Title:    *title,
+				Started:  startTime,
+				Duration: totalDuration,
+				Failed:   len(failing),
+				Failing:  failing,
+			}
+			for _, r := range scriptResults {
+				if r.Success && !r.Skipped {
+					payload.Successful++
+				}
+			}
+			// Without a previous run everything counts as changed, so only
+			// failures are worth a ping.
+			changed := len(failing) > 0
+			if hist != nil && len(hist.Runs) > 0 {
+				prev := hist.Runs[len(hist.Runs)-1].failing()
+				for _, name := range failing {
+					if !slices.Contains(prev, name) {
+						payload.NewlyFailing = append(payload.NewlyFailing, name)
+					}
+				}
+				for _, name := range prev {
+					if !slices.Contains(failing, name) {
+						payload.Recovered = append(payload.Recovered, name)
+					}
+				}
+				changed = !slices.Equal(prev, failing)
+			}
+			if !*notifyOnChange || changed {
+				if err := postWebhook(*webhook, payload); err != nil {
+					slog.Error("failed to send webhook", "url", *webhook, "error", err)
+				}
+			} else {
+				slog.Info("failing exchanges unchanged; webhook skipped", "failing", failing)
+			}
+		}
+		if hist != nil {
+			if err := appendHistory(*historyPath, thisRun, *historyLimit); err != nil {
+				slog.Error("failed to save history", "path", *historyPath, "error", err)
+			}
+			// The next -repeat run compares against this one.
+			hist.Runs = append(hist.Runs, thisRun)
+		}
+		if *sqlitePath != "" {
+			if err := appendSQLite(*sqlitePath, rep); err != nil {
+				slog.Error("failed to save run to SQLite", "path", *sqlitePath, "error", err)
+			}
+		}
+		if *reportPath != "" {
+			if err := writeReport(*reportPath, rep); err != nil {
+				slog.Error("failed to write report", "path", *reportPath, "error", err)
+			}
+		}
+		if *failuresDir != "" {
+			if n, err := writeFailures(*failuresDir, scriptResults); err != nil {
+				slog.Error("failed to write failure details", "dir", *failuresDir, "error", err)
+			} else if n > 0 {
+				slog.Info("wrote failure details", "dir", *failuresDir, "failures", n)
+			}
+		}
+		if *mergePath != "" {
+			rows, err := writeMerged(*mergePath, scriptDir, validScripts, scriptResults, runOpts.OutputFormat)
+			if err != nil {
+				slog.Error("failed to write merged output", "path", *mergePath, "error", err)
+			} else {
+				slog.Info("wrote merged output", "path", *mergePath, "symbols", rows)
+			}
+		}
+
+		// A -template replaces the built-in summary, which still runs
+		// for the counts and gates.
+		summaryOut := console
+		if *noSummary || tmpl != nil {
+			console = io.Discard
+		}
+		fmt.Fprintln(console, "\n"+strings.Repeat("=", 60))
+		totalText := roundDuration(totalDuration).String()
+		if paused > 0 {
+			totalText += fmt.Sprintf(", active %v, paused %v", roundDuration(totalDuration-paused), roundDuration(paused))
+		}
+		if *title != "" {
+			fmt.Fprintf(console, "Execution Summary: %s (Total time: %s)\n", *title, totalText)
+		} else {
+			fmt.Fprintf(console, "Execution Summary (Total time: %s)\n", totalText)
+		}
+		fmt.Fprintln(console, strings.Repeat("=", 60))
+
+		successful := 0
+		failed := 0
+		skipped := 0
+		partial := 0
+		totalSymbols := 0
+		var failedScripts []ScriptResult
+
+		for _, result := range scriptResults {
+			// Script time, and the API's share of it when the script reports one.
+			took := roundDuration(result.Duration).String()
+			if result.APIDuration > 0 {
+				took += fmt.Sprintf(" (API %v)", roundDuration(result.APIDuration))
+			}
+			stats := ""
+			if slices.Contains(required, result.Name) {
+				stats += " [required]"
+			}
+			if result.Change != "" {
+				stats += " (" + result.Change + ")"
+			}
+			if len(result.Stats) > 0 {
+				stats += "  [" + formatStats(result.Stats) + "]"
+			}
+			totalSymbols += result.Symbols
+			if result.Skipped {
+				reason := ""
+				if result.Error != nil {
+					reason = ": " + result.Error.Error()
+				}
+				fmt.Fprintf(console, "⏭ %-15s - %v, skipped%s%s\n", result.Name, roundDuration(result.Duration), reason, stats)
+				skipped++
+			} else if result.Partial {
+				fmt.Fprintf(console, "◐ %-15s - %s, %d symbols (PARTIAL: %v)%s\n", result.Name, took, result.Symbols, result.Error, stats)
+				partial++
+			} else if result.Success {
+				fmt.Fprintf(console, "✓ %-15s - %s, %d symbols%s\n", result.Name, took, result.Symbols, stats)
+				successful++
+			} else {
+				fmt.Fprintf(console, "✗ %-15s - %s, %d symbols (ERROR)%s\n", result.Name, took, result.Symbols, stats)
+				if result.Summary != "" {
+					fmt.Fprintf(console, "    ↳ %s\n", result.Summary)
+				}
+				failedScripts = append(failedScripts, result)
+				failed++
+			}
+		}
+
+		totals := map[string]float64{}
+		for _, result := range scriptResults {
+			for k, v := range result.Stats {
+				totals[k] += v
+			}
+		}
+
+		fmt.Fprintln(console, strings.Repeat("-", 60))
+		counts := fmt.Sprintf("%d successful, %d failed", successful, failed)
+		if partial > 0 {
+			counts += fmt.Sprintf(", %d partial", partial)
+		}
+		if skipped > 0 {
+			counts += fmt.Sprintf(", %d skipped", skipped)
+		}
+		fmt.Fprintf(console, "Results: %s, %d symbols total\n", counts, totalSymbols)
+		if limitSkipped > 0 {
+			fmt.Fprintf(console, "Limit: ran the first %d selected exchanges (-limit), %d more skipped\n", len(validScripts), limitSkipped)
+		}
+		for i, b := range runner.Batches {
+			if i == 0 {
+				fmt.Fprintln(console, "Batches:")
+			}
+			fmt.Fprintf(console, "  %d. %d script(s) in %v\n", i+1, b.Jobs, b.Duration.Round(time.Millisecond))
+		}
+		if len(quarantined) > 0 {
+			var names []string
+			for _, name := range slices.Sorted(maps.Keys(quarantined)) {
+				names = append(names, fmt.Sprintf("%s (%d failures)", name, quarantined[name]))
+			}
+			verb := "skipped"
+			if release {
+				verb = "run again (-unquarantine)"
+			}
+			fmt.Fprintf(console, "🔒 Quarantined, %s: %s\n", verb, strings.Join(names, ", "))
+		}
+
+		if len(totals) > 0 {
+			fmt.Fprintf(console, "Stats: %s\n", formatStats(totals))
+		}
+		if *maxMsPerSymbol > 0 {
+			var slow []ScriptResult
+			for _, result := range scriptResults {
+				if result.MsPerSymbol > *maxMsPerSymbol {
+					slow = append(slow, result)
+				}
+			}
+			if len(slow) > 0 {
+				fmt.Fprintf(console, "\n🐢 Slow relative to output (> %gms per symbol):\n", *maxMsPerSymbol)
+				for _, result := range slow {
+					fmt.Fprintf(console, "  %-15s %.0fms/symbol (%d symbols in %v)\n",
+						result.Name, result.MsPerSymbol, result.Symbols, roundDuration(result.Duration))
+				}
+			}
+		}
+		// A format flip is usually a config mistake or an exchange API change.
+		var flipped []ScriptResult
+		for _, result := range scriptResults {
+			if result.PreviousFormat != "" {
+				flipped = append(flipped, result)
+			}
+		}
+		if *symbolConflicts {
+			printSymbolConflicts(findSymbolConflicts(scriptDir, validScripts, scriptResults, runOpts.OutputFormat))
+		}
+		// Under -strict-format these are failures and listed as such.
+		if !runOpts.StrictFormat {
+			var mismatched []ScriptResult
+			for _, result := range scriptResults {
+				if result.FormatMismatch != "" {
+					mismatched = append(mismatched, result)
+				}
+			}
+			if len(mismatched) > 0 {
+				fmt.Fprintln(console, "\n⚠ Output contradicts the declared symbol format (fatal under -strict-format):")
+				for _, result := range mismatched {
+					fmt.Fprintf(console, "  %-15s %s\n", result.Name, result.FormatMismatch)
+					slog.Warn("symbol format mismatch", "exchange", result.Name, "mismatch", result.FormatMismatch)
+				}
+			}
+		}
+		if len(flipped) > 0 {
+			fmt.Fprintln(console, "\n⚠ Symbol format changed since the previous run:")
+			for _, result := range flipped {
+				fmt.Fprintf(console, "  %-15s %s → %s\n", result.Name, result.PreviousFormat, result.Format)
+				slog.Warn("symbol format changed", "exchange", result.Name, "before", result.PreviousFormat, "after", result.Format)
+			}
+		}
+
+		if *showStats {
+			if st := computeDurationStats(scriptResults); st != nil {
+				fmt.Fprintf(console, "Durations: total %v, min %v, p50 %v, p90 %v, p99 %v, max %v\n",
+					roundDuration(st.Total), roundDuration(st.Min), roundDuration(st.P50), roundDuration(st.P90), roundDuration(st.P99), roundDuration(st.Max))
+			}
+		}
+		if *repeatEach > 1 {
+			printFlakiness(scriptResults)
+		}
+
+		if len(failedScripts) > 0 {
+			fmt.Fprintln(console, "\nFailed Scripts Details:")
+			fmt.Fprintln(console, strings.Repeat("-", 60))
+			for _, result := range failedScripts {
+				fmt.Fprintf(console, "\n%s:\n", result.Name)
+				fmt.Fprintf(console, "Error: %v\n", result.Error)
+				if result.Summary != "" {
+					fmt.Fprintf(console, "Exception: %s\n", result.Summary)
+				}
+				// stderr usually holds the error; fall back to everything.
+				if len(result.StderrText) > 0 {
+					fmt.Fprintf(console, "Stderr (last %d lines):\n%s\n", failureTailLines, lastLines(result.StderrText, failureTailLines))
+				} else if len(result.Output) > 0 {
+					fmt.Fprintf(console, "Output (last %d lines):\n%s\n", failureTailLines, lastLines(result.Output, failureTailLines))
+				}
+				if result.LogFile != "" {
+					fmt.Fprintf(console, "Full output: %s\n", result.LogFile)
+				}
+			}
+		}
+		if *explainFailures && len(failedScripts) > 0 {
+			fmt.Fprintln(console, "\n💡 Likely causes:")
+			for _, result := range failedScripts {
+				if category, suggestion, ok := explainFailure(result); ok {
+					fmt.Fprintf(console, "  %-15s %s: %s\n", result.Name, category, suggestion)
+				} else {
+					fmt.Fprintf(console, "  %-15s no known cause; see the output above\n", result.Name)
+				}
+			}
+		}
+
+		exitCode := 0
+		if failed > 0 && failBase != nil {
+			// Only failures the baseline didn't have gate the run.
+			fresh, known := newFailures(failBase, scriptResults)
+			if len(known) > 0 {
+				fmt.Fprintf(console, "\n⚠ Already failing in %s, not gating: %s\n", *failOnNewFailure, strings.Join(known, ", "))
+			}
+			if len(fresh) > 0 {
+				fmt.Fprintf(console, "\n✗ New failure(s) against %s: %s\n", *failOnNewFailure, strings.Join(fresh, ", "))
+				exitCode = 1
+			}
+		} else if failed > 0 {
+			// The default threshold of 0 fails on any failure.
+			pct := float64(failed) / float64(successful+failed+partial) * 100
+			if pct > *failThreshold {
+				exitCode = 1
+			} else {
+				fmt.Fprintf(console, "\n⚠ %d of %d scripts failed (%.1f%%), within -fail-threshold-percent %g\n", failed, successful+failed+partial, pct, *failThreshold)
+			}
+		}
+		// A required exchange fails the run even within the threshold;
+		// one that never ran, e.g. filtered out, skipped for a failed
+		// dependency or past -soft-deadline, doesn't.
+		var requiredFailed []string
+		for _, result := range scriptResults {
+			if !result.Success && !result.Skipped && slices.Contains(required, result.Name) && !slices.Contains(requiredFailed, result.Name) {
+				requiredFailed = append(requiredFailed, result.Name)
+			}
+		}
+		if len(requiredFailed) > 0 {
+			fmt.Fprintf(console, "\n✗ Required exchange(s) failed: %s\n", strings.Join(requiredFailed, ", "))
+			exitCode = 1
+		}
+		if *failOnEmptyTotal && totalSymbols == 0 {
+			fmt.Fprintln(console, "\n✗ No symbols were produced by any exchange (-fail-on-empty-total)")
+			exitCode = 1
+		}
+		if baseline != nil {
+			regressions := compareBaseline(baseline, scriptResults, *baselineSlowdown, *baselineSymbolDrop)
+			printRegressions(*baselinePath, regressions)
+			if len(regressions) > 0 {
+				exitCode = 1
+			}
+		}
+		if tmpl != nil {
+			data := templateData{
+				Title: *title, Started: startTime, Duration: totalDuration,
+				ScriptsCommit: rep.ScriptsCommit, RunnerVersion: rep.RunnerVersion,
+				Results: scriptResults, Successful: successful, Failed: failed, Partial: partial, Skipped: skipped,
+				Symbols: totalSymbols, ExitCode: exitCode,
+			}
+			if err := tmpl.Execute(summaryOut, data); err != nil {
+				slog.Error("failed to render -template", "path", *templatePath, "error", err)
+			}
+		}
+		if quietBuf != nil {
+			if exitCode == 0 {
+				label := ""
+				if *title != "" {
+					label = " " + *title + ":"
+				}
+				fmt.Fprintf(quietOut, "OK:%s %d scripts succeeded, %d symbols in %v\n", label, successful, totalSymbols, roundDuration(totalDuration))
+			} else {
+				io.WriteString(quietOut, quietBuf.String())
+			}
+			quietBuf.reset()
+		}
+		return rep, exitCode
+	}
+
+	if *repeat == 0 {
+		_, exitCode := runOnce()
+		flushOutput()
+		os.Exit(exitCode)
+	}
+	var status *statusServer
+	if *httpAddr != "" {
+		status, err = startStatusServer(*httpAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	for {
+		rep, exitCode := runOnce()
+		next := time.Now().Add(*repeat)
+		if status != nil {
+			status.update(rep, exitCode, next)
+		}
+		flushOutput()
+		if ctx.Err() != nil {
+			if status != nil {
+				status.shutdown()
+			}
+			os.Exit(exitCode)
+		}
+		slog.Info("next run scheduled", "at", next.Format(time.TimeOnly), "exit_code", exitCode)
+		select {
+		case <-ctx.Done():
+			if status != nil {
+				status.shutdown()
+			}
+			os.Exit(exitCode)
+		case <-time.After(*repeat):
+		}
 	}
 }
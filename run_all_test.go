@@ -0,0 +1,1041 @@
+package main
+
+// The scripts directory has no go.mod; run these with
+//
+//	go test run_all.go run_all_test.go
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Scripts with resource limits start this binary as their helper.
+	runLimitHelper()
+	// runMain re-executes the test binary to run main in a process of its
+	// own, since main exits.
+	if os.Getenv("RUN_ALL_TEST_MAIN") == "1" {
+		args := os.Args[slices.Index(os.Args, "--")+1:]
+		os.Args = append([]string{"run_all"}, args...)
+		flag.CommandLine = flag.NewFlagSet("run_all", flag.ExitOnError)
+		main()
+		os.Exit(0)
+	}
+	console, scriptStderr = io.Discard, io.Discard
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// runMain runs the runner with args and returns its exit code and stderr.
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "RUN_ALL_TEST_MAIN=1")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), stderr.String()
+}
+
+// writeStub writes a shell script into dir and returns its file name; the
+// tests run stubs with /bin/sh as the interpreter.
+func writeStub(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	file := name + ".sh"
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// processGone reports whether pid has exited, counting an unreaped zombie
+// as gone.
+func processGone(pid int) bool {
+	if errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	// pid (comm) state ...; comm may contain spaces.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestRunScriptKillsGroupIgnoringSIGTERM(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	// The ignored TERM is inherited by the sleeper, so only SIGKILL to the
+	// whole group stops either of them.
+	script := writeStub(t, dir, "stubborn", `trap '' TERM
+sleep 60 &
+echo $! > `+pidFile+`
+wait
+wait
+`)
+	opts := runOptions{Interpreter: "/bin/sh", Timeout: 300 * time.Millisecond, KillGrace: 500 * time.Millisecond}
+
+	start := time.Now()
+	result := runScript(context.Background(), dir, Exchange{Name: "stubborn", Script: script}, opts, 1, 1)
+	elapsed := time.Since(start)
+
+	if result.Success {
+		t.Fatal("stub succeeded, want a timeout")
+	}
+	if !strings.Contains(result.Error.Error(), "timed out") {
+		t.Errorf("error = %v, want a timeout", result.Error)
+	}
+	const epsilon = 700 * time.Millisecond
+	if limit := opts.Timeout + opts.KillGrace + epsilon; elapsed > limit {
+		t.Errorf("runScript took %v, want at most %v", elapsed, limit)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child %d survived the script's termination", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cancellableJobs writes n stubs that mark themselves started, run until
+// terminated and take a moment to clean up before marking themselves
+// finished, so a Run returning early would miss the finished marker.
+func cancellableJobs(t *testing.T, dir string, n int) []Exchange {
+	t.Helper()
+	var jobs []Exchange
+	for i := range n {
+		name := string(rune('a' + i))
+		script := writeStub(t, dir, name, `trap 'sleep 0.2; touch `+name+`.finished; exit 143' TERM
+touch `+name+`.started
+sleep 30 &
+wait $!
+`)
+		jobs = append(jobs, Exchange{Name: name, Script: script})
+	}
+	return jobs
+}
+
+// markers returns the names of the jobs that left dir/<name>.<suffix>.
+func markers(dir string, jobs []Exchange, suffix string) []string {
+	var names []string
+	for _, ex := range jobs {
+		if fileExists(filepath.Join(dir, ex.Name+"."+suffix)) {
+			names = append(names, ex.Name)
+		}
+	}
+	return names
+}
+
+func TestRunnerRunStopsOnCancellation(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel int
+		// stop returns the context for Run, set up to end mid-run.
+		stop    func() (context.Context, context.CancelFunc)
+		started []string
+		cause   string
+	}{
+		{
+			name:     "cancelled sequential",
+			parallel: 1,
+			stop: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancelCause(context.Background())
+				time.AfterFunc(300*time.Millisecond, func() { cancel(errors.New("received terminated")) })
+				return ctx, func() { cancel(nil) }
+			},
+			started: []string{"a"},
+			cause:   "cancelled: received terminated",
+		},
+		{
+			name:     "cancelled parallel",
+			parallel: 2,
+			stop: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancelCause(context.Background())
+				time.AfterFunc(300*time.Millisecond, func() { cancel(errors.New("received interrupt")) })
+				return ctx, func() { cancel(nil) }
+			},
+			started: []string{"a", "b"},
+			cause:   "cancelled: received interrupt",
+		},
+		{
+			name:     "max-total deadline",
+			parallel: 3,
+			stop: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeoutCause(context.Background(), 300*time.Millisecond, errors.New("-max-total of 300ms reached"))
+			},
+			started: []string{"a", "b", "c"},
+			cause:   "cancelled: -max-total of 300ms reached",
+		},
+		{
+			name:     "cancelled before start",
+			parallel: 2,
+			stop: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			jobs := cancellableJobs(t, dir, 5)
+			runner := &Runner{
+				ScriptDir: dir,
+				Jobs:      jobs,
+				Options:   runOptions{Interpreter: "/bin/sh", KillGrace: 2 * time.Second},
+				Retry:     &retryPolicy{Retries: 2},
+				Parallel:  tt.parallel,
+			}
+			ctx, cancel := tt.stop()
+			defer cancel()
+
+			start := time.Now()
+			results := runner.Run(ctx)
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Run took %v after cancellation", elapsed)
+			}
+
+			started := markers(dir, jobs, "started")
+			if !slices.Equal(started, tt.started) {
+				t.Errorf("started %v, want %v", started, tt.started)
+			}
+			// Every started script has exited, clean-up included, by the
+			// time Run returns.
+			if finished := markers(dir, jobs, "finished"); !slices.Equal(finished, started) {
+				t.Errorf("finished %v when Run returned, want %v", finished, started)
+			}
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Name)
+				if r.Success {
+					t.Errorf("%s succeeded", r.Name)
+				} else if !strings.Contains(r.Error.Error(), tt.cause) {
+					t.Errorf("%s: error %q, want it to contain %q", r.Name, r.Error, tt.cause)
+				}
+				if len(r.Attempts) != 1 {
+					t.Errorf("%s: %d attempts, want no retry after cancellation", r.Name, len(r.Attempts))
+				}
+			}
+			if !slices.Equal(names, tt.started) {
+				t.Errorf("results for %v, want only the started %v", names, tt.started)
+			}
+		})
+	}
+}
+
+func TestMergedOutputOfParallelRun(t *testing.T) {
+	const exchanges, symbols = 24, 50
+	dir := t.TempDir()
+	var jobs []Exchange
+	for i := range exchanges {
+		name := fmt.Sprintf("x%02d", i)
+		// Random pauses shuffle how the writes of parallel scripts
+		// interleave on disk.
+		script := writeStub(t, dir, name, fmt.Sprintf(`mkdir -p data_%[1]s_1d
+for j in $(seq %[2]d); do
+	printf 'date,close\n2024-01-01,1\n' > data_%[1]s_1d/%[1]s_$j"_1d.csv"
+	if [ $((j %% 7)) -eq 0 ]; then sleep 0.0$((j %% 3)); fi
+done
+`, name, symbols))
+		jobs = append(jobs, Exchange{Name: name, Script: script})
+	}
+	runner := &Runner{
+		ScriptDir: dir,
+		Jobs:      jobs,
+		Options:   runOptions{Interpreter: "/bin/sh", OutputFormat: "csv", KillGrace: time.Second},
+		Retry:     &retryPolicy{},
+		Parallel:  8,
+	}
+	results := runner.Run(context.Background())
+	for _, r := range results {
+		if !r.Success {
+			t.Fatalf("%s failed: %v\n%s", r.Name, r.Error, r.Output)
+		}
+	}
+	if shared := sharedOutputs(dir, jobs); len(shared) > 0 {
+		t.Fatalf("sharedOutputs = %v for separate directories", shared)
+	}
+
+	// Runners sharing a -merge path replace it whole, never interleave.
+	merged := filepath.Join(t.TempDir(), "merged.csv")
+	errs := make(chan error, 4)
+	for range cap(errs) {
+		go func() {
+			_, err := writeMerged(merged, dir, jobs, results, "csv")
+			errs <- err
+		}()
+	}
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("merged output is not valid CSV: %v", err)
+	}
+	if len(rows) == 0 || !slices.Equal(rows[0], []string{"exchange", "symbol"}) {
+		t.Fatalf("merged output lacks its header: %v", rows[:min(len(rows), 1)])
+	}
+	rows = rows[1:]
+	if len(rows) != exchanges*symbols {
+		t.Errorf("merged %d rows, want %d", len(rows), exchanges*symbols)
+	}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		key := strings.Join(row, ",")
+		if seen[key] {
+			t.Errorf("row %s merged twice", key)
+		}
+		seen[key] = true
+		if !strings.HasPrefix(row[1], row[0]+"_") {
+			t.Errorf("symbol %s attributed to %s", row[1], row[0])
+		}
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(merged), ".merged.csv.tmp-*")); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestSharedOutputsRejected(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		writeStub(t, dir, name, "mkdir -p data_"+name+"_1d\n")
+	}
+	tests := []struct {
+		name   string
+		config string
+		merge  string
+		want   string
+	}{
+		{
+			name:   "shared output directory",
+			config: `{"exchanges": [{"name": "a", "script": "a.sh", "enabled": true, "output": "shared"}, {"name": "b", "script": "b.sh", "enabled": true, "output": "shared"}]}`,
+			want:   "shared: a, b",
+		},
+		{
+			name:   "merge inside an output directory",
+			config: `{"exchanges": [{"name": "a", "script": "a.sh", "enabled": true}, {"name": "b", "script": "b.sh", "enabled": true}]}`,
+			merge:  filepath.Join(dir, "data_b_1d", "merged.csv"),
+			want:   "inside b's output directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(config, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"-config", config, "-interpreter", "/bin/sh", "-history", ""}
+			if tt.merge != "" {
+				args = append(args, "-merge", tt.merge)
+			}
+			code, stderr := runMain(t, append(args, dir)...)
+			if code != 2 {
+				t.Errorf("exit code %d, want 2; stderr:\n%s", code, stderr)
+			}
+			if !strings.Contains(stderr, tt.want) {
+				t.Errorf("stderr lacks %q:\n%s", tt.want, stderr)
+			}
+			if fileExists(filepath.Join(dir, "data_a_1d")) {
+				t.Error("a script ran despite the rejected layout")
+			}
+		})
+	}
+}
+
+func TestRunScriptResourceLimits(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name        string
+		interpreter string
+		body        string
+		ex          Exchange
+		want        string
+	}{
+		{
+			name:        "cpu",
+			interpreter: "/bin/sh",
+			body:        "while :; do :; done\n",
+			ex:          Exchange{CPULimit: time.Second},
+			want:        "cpu limit of 1s exceeded",
+		},
+		{
+			name:        "memory",
+			interpreter: "python3",
+			body:        "x = bytearray(1 << 30)\n",
+			ex:          Exchange{MemLimit: 256 << 20},
+			want:        "memory limit of 256 MiB exceeded",
+		},
+		{
+			// The limits must not change what the script is started with.
+			name:        "arguments kept",
+			interpreter: "/bin/sh",
+			body:        `[ "$(basename "$0")" = args.sh ] && [ -z "$` + limitsEnv + `" ] && [ "$(ulimit -t)" = 5 ]` + "\n",
+			ex:          Exchange{Name: "args", CPULimit: 5 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.interpreter); err != nil {
+				t.Skip(err)
+			}
+			ex := tt.ex
+			ex.Name = cmp.Or(ex.Name, tt.name)
+			ex.Script = writeStub(t, dir, ex.Name, tt.body)
+			result := runScript(context.Background(), dir, ex, runOptions{Interpreter: tt.interpreter, Timeout: 10 * time.Second, KillGrace: time.Second}, 1, 1)
+			switch {
+			case tt.want == "" && !result.Success:
+				t.Errorf("failed: %v\n%s", result.Error, result.Output)
+			case tt.want != "" && (result.Error == nil || !strings.Contains(result.Error.Error(), tt.want)):
+				t.Errorf("error = %v, want %q", result.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverExchangesToleratesUndescribedScripts(t *testing.T) {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		// Run through /bin/sh; "--describe" in the source opts a script in.
+		"described.py": `[ "$1" = --describe ] && echo '{"exchange": "newex", "format": "remove_dash"}'` + "\n",
+		"broken.py":    `[ "$1" = --describe ] && exit 3` + "\n",
+		"plain.py":     "touch scraped\n",
+		"bybit.py":     "touch scraped\n",
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, ex := range discoverExchanges("/bin/sh", dir) {
+		got = append(got, fmt.Sprintf("%s:%s:%s:%v", ex.Name, ex.Script, ex.Format, ex.Enabled))
+	}
+	slices.Sort(got)
+	// bybit keeps its roster entry, so it isn't rediscovered.
+	want := []string{"broken:broken.py::false", "newex:described.py:remove_dash:true", "plain:plain.py::false"}
+	if !slices.Equal(got, want) {
+		t.Errorf("discovered %v, want %v", got, want)
+	}
+	if fileExists(filepath.Join(dir, "scraped")) {
+		t.Error("a script without --describe support was run")
+	}
+}
+
+// testSchema compiles a schema given as JSON, the way loadSchema does.
+func testSchema(t *testing.T, src string) *jsonSchema {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		keyword string
+		schema  string
+		doc     string
+		want    []string
+	}{
+		{"type", `{"type": "object"}`, `[]`, []string{"/: want object, got array"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type integer", `{"type": "integer"}`, `1.5`, []string{"/: want integer, got number"}},
+		{"type number", `{"type": "number"}`, `3`, nil},
+		{"enum", `{"enum": ["1d", "1h"]}`, `"5m"`, []string{"/: 5m is not one of the allowed values"}},
+		{"required", `{"required": ["close", "date"]}`, `{"date": "x"}`, []string{`/: missing required property "close"`}},
+		{"properties", `{"properties": {"close": {"type": "number"}}}`, `{"close": "1"}`, []string{"/close: want number, got string"}},
+		{"additionalProperties", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{`/: unexpected property "b"`}},
+		{"additionalProperties true", `{"properties": {"a": {}}, "additionalProperties": true}`, `{"b": 2}`, nil},
+		{"items", `{"items": {"type": "string"}}`, `["a", 1, "c", false]`, []string{"/1: want string, got integer", "/3: want string, got boolean"}},
+		{"pattern", `{"pattern": "^[A-Z]+$"}`, `"btc"`, []string{`/: "btc" does not match ^[A-Z]+$`}},
+		{"minLength", `{"minLength": 3}`, `"€€"`, []string{`/: "€€" is shorter than 3`}},
+		{"maxLength", `{"maxLength": 2}`, `"€€"`, nil},
+		{"minimum", `{"minimum": 0}`, `-1`, []string{"/: -1 is below the minimum 0"}},
+		{"maximum", `{"maximum": 10}`, `10.5`, []string{"/: 10.5 is above the maximum 10"}},
+		{"minItems", `{"minItems": 2}`, `[1]`, []string{"/: 1 items, want at least 2"}},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{"/: 2 items, want at most 1"}},
+		{
+			"nested pointer",
+			`{"properties": {"rows": {"items": {"properties": {"close": {"minimum": 0}}}}}}`,
+			`{"rows": [{"close": 1}, {"close": -2}]}`,
+			[]string{"/rows/1/close: -2 is below the minimum 0"},
+		},
+		{
+			"escaped pointer",
+			`{"properties": {"a/b": {"type": "string"}, "m~n": {"type": "string"}}}`,
+			`{"a/b": 1, "m~n": 2}`,
+			[]string{"/a~1b: want string, got integer", "/m~0n: want string, got integer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			var doc any
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := testSchema(t, tt.schema).validate(doc, "")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("validate(%s) = %q, want %q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`{"$ref": "#/definitions/row"}`, "$ref: unsupported keyword"},
+		{`{"oneOf": [{"type": "string"}]}`, "oneOf: unsupported keyword"},
+		{`{"anyOf": []}`, "anyOf: unsupported keyword"},
+		{`{"allOf": []}`, "allOf: unsupported keyword"},
+		{`{"not": {}}`, "not: unsupported keyword"},
+		{`{"type": "string", "format": "date"}`, "format: unsupported keyword"},
+		{`{"const": 1}`, "const: unsupported keyword"},
+		{`{"patternProperties": {}}`, "patternProperties: unsupported keyword"},
+		{`{"additionalProperties": {"type": "string"}}`, "additionalProperties: only true or false"},
+		{`{"properties": {"close": {"exclusiveMinimum": 0}}}`, "properties.close.exclusiveMinimum: unsupported keyword"},
+		{`{"items": {"properties": {"d": {"format": "date"}}}}`, "items.properties.d.format: unsupported keyword"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "schema.json")
+		if err := os.WriteFile(path, []byte(tt.schema), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadSchema(path)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadSchema(%s) error = %v, want %q", tt.schema, err, tt.want)
+		}
+	}
+	// Annotations never change the outcome and are fine.
+	testSchema(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "row", "description": "one candle", "properties": {"close": {"description": "price", "default": 0}}}`)
+}
+
+func TestLastTraceback(t *testing.T) {
+	chained := `fetching BTCUSDT
+Traceback (most recent call last):
+  File "bybit.py", line 10, in fetch
+    raise KeyError("data")
+KeyError: 'data'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "bybit.py", line 20, in <module>
+    main()
+RuntimeError: giving up
+done`
+	tests := []struct {
+		name, output, traceback, exception string
+	}{
+		{"none", "all good\n", "", ""},
+		{"last of chained", chained, "Traceback (most recent call last):\n  File \"bybit.py\", line 20, in <module>\n    main()\nRuntimeError: giving up", "RuntimeError: giving up"},
+		{"crlf", "Traceback (most recent call last):\r\n  File \"x.py\"\r\nValueError: bad\r\n", "Traceback (most recent call last):\r\n  File \"x.py\"\r\nValueError: bad\r", "ValueError: bad"},
+		{"cut off", "Traceback (most recent call last):\n  File \"x.py\", line 1", "Traceback (most recent call last):\n  File \"x.py\", line 1", ""},
+	}
+	for _, tt := range tests {
+		tb, exception := lastTraceback(tt.output)
+		if tb != tt.traceback || exception != tt.exception {
+			t.Errorf("%s: lastTraceback = %q, %q; want %q, %q", tt.name, tb, exception, tt.traceback, tt.exception)
+		}
+		if got := tracebackSummary(tt.output); got != tt.exception {
+			t.Errorf("%s: tracebackSummary = %q, want %q", tt.name, got, tt.exception)
+		}
+	}
+}
+
+func TestExplainFailure(t *testing.T) {
+	tests := []struct {
+		name, stderr, category string
+	}{
+		{"connection error quoting the key", `Traceback (most recent call last):
+  File "bybit.py", line 31, in <module>
+    resp = session.get(url, params={"api_key": key, "signature": sig})
+requests.exceptions.ConnectionError: HTTPSConnectionPool(host='api.bybit.com', port=443): Max retries exceeded with url: /v5/market/instruments-info?api_key=abc&signature=def (Caused by NewConnectionError('<urllib3.connection.HTTPSConnection object at 0x7f>: Failed to establish a new connection: [Errno 111] Connection refused'))`, "network"},
+		{"bad gateway", `requests.exceptions.HTTPError: 502 Server Error: Bad Gateway for url: https://api.kraken.com/0/public/AssetPairs`, "network"},
+		{"429 quoting the key", `requests.exceptions.HTTPError: 429 Client Error: Too Many Requests for url: https://api.binance.com/api/v3/exchangeInfo?api_key=abc&signature=def`, "rate limit"},
+		{"urllib3 retries on 429", `requests.exceptions.RetryError: HTTPSConnectionPool(host='api.okx.com', port=443): Max retries exceeded with url: /api/v5/public/instruments (Caused by ResponseError('too many 429 error responses'))`, "rate limit"},
+		{"ccxt rate limit", `ccxt.base.errors.RateLimitExceeded: kucoin {"code":"429000","msg":"Too Many Requests"}`, "rate limit"},
+		{"401", `requests.exceptions.HTTPError: 401 Client Error: Unauthorized for url: https://api.bybit.com/v5/account/info`, "auth"},
+		{"403", `requests.exceptions.HTTPError: 403 Client Error: Forbidden for url: https://api.gemini.com/v1/symbols`, "auth"},
+		{"ccxt auth", `ccxt.base.errors.AuthenticationError: bybit {"retCode":10003,"retMsg":"API key is invalid.","result":{}}`, "auth"},
+		{"binance key format", `RuntimeError: {"code":-2014,"msg":"API-key format invalid."}`, "auth"},
+		{"tls", `requests.exceptions.SSLError: HTTPSConnectionPool(host='api.kraken.com', port=443): Max retries exceeded with url: /0/public/AssetPairs (Caused by SSLError(SSLCertVerificationError(1, '[SSL: CERTIFICATE_VERIFY_FAILED] certificate verify failed: unable to get local issuer certificate (_ssl.c:1006)')))`, "tls"},
+		{"missing module", `ModuleNotFoundError: No module named 'tvDatafeed'`, "dependency missing"},
+		{"missing field", `Traceback (most recent call last):
+  File "gemini.py", line 12, in <module>
+    symbols = resp.json()["result"]
+KeyError: 'result'`, "bad response"},
+	}
+	for _, tt := range tests {
+		r := ScriptResult{Name: "bybit", Error: errors.New("exit status 1"), StderrText: tt.stderr, Summary: tracebackSummary(tt.stderr)}
+		category, _, ok := explainFailure(r)
+		if !ok || category != tt.category {
+			t.Errorf("%s: explainFailure = %q, %v; want %q", tt.name, category, ok, tt.category)
+		}
+	}
+	if _, suggestion, _ := explainFailure(ScriptResult{Name: "bybit", StderrText: "401 Client Error: Unauthorized"}); !strings.Contains(suggestion, "BYBIT_API_KEY") {
+		t.Errorf("auth suggestion = %q, want it to name BYBIT_API_KEY", suggestion)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	sequence := func(jitter string, seed uint64) []time.Duration {
+		p := newRetryPolicy(10, time.Second, jitter, seed)
+		var seq []time.Duration
+		var prev time.Duration
+		for attempt := 1; attempt <= 10; attempt++ {
+			prev = p.backoff(attempt, prev)
+			seq = append(seq, prev)
+		}
+		return seq
+	}
+	want := []time.Duration{1, 2, 4, 8, 16, 32, 64, 128, 256, 300}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if got := sequence("none", 0); !slices.Equal(got, want) {
+		t.Errorf("none: backoff = %v, want %v", got, want)
+	}
+	for _, jitter := range []string{"full", "decorrelated"} {
+		a, b := sequence(jitter, 42), sequence(jitter, 42)
+		if !slices.Equal(a, b) {
+			t.Errorf("%s: seed 42 gave %v, then %v", jitter, a, b)
+		}
+		if c := sequence(jitter, 43); slices.Equal(a, c) {
+			t.Errorf("%s: seeds 42 and 43 both gave %v", jitter, a)
+		}
+		for i, d := range a {
+			lo, hi := time.Duration(0), want[i]
+			if jitter == "decorrelated" {
+				lo, hi = time.Second, maxRetryDelay
+			}
+			if d < lo || d > hi {
+				t.Errorf("%s: retry %d slept %v, want it within [%v, %v]", jitter, i+1, d, lo, hi)
+			}
+		}
+	}
+
+	// -retries 100 reaches attempts whose doubling would overflow.
+	for _, jitter := range []string{"none", "full", "decorrelated"} {
+		p := newRetryPolicy(100, time.Second, jitter, 42)
+		var prev time.Duration
+		for attempt := 1; attempt <= 100; attempt++ {
+			prev = p.backoff(attempt, prev)
+			if prev < 0 || prev > maxRetryDelay {
+				t.Fatalf("%s: retry %d slept %v, want it within [0, %v]", jitter, attempt, prev, maxRetryDelay)
+			}
+			if jitter == "none" && attempt == 64 && prev != maxRetryDelay {
+				t.Errorf("none: retry 64 slept %v, want the %v cap", prev, maxRetryDelay)
+			}
+		}
+	}
+}
+
+func TestParseTagExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		tags []string
+		want bool
+	}{
+		{"us", []string{"us", "spot"}, true},
+		{"us", []string{"eu"}, false},
+		{"a OR b AND c", []string{"a"}, true},
+		{"a OR b AND c", []string{"b"}, false},
+		{"a OR b AND c", []string{"b", "c"}, true},
+		{"(a OR b) AND c", []string{"a"}, false},
+		{"NOT a AND b", []string{"b"}, true},
+		{"NOT a AND b", []string{"a", "b"}, false},
+		{"NOT (a AND b)", []string{"a"}, true},
+		{"!a || b&&c", []string{"a", "c"}, false},
+		{"!a || b&&c", []string{"a", "b", "c"}, true},
+		{"not not a", []string{"a"}, true},
+		{"us and (spot or not high-volume)", []string{"us", "high-volume"}, false},
+		{"us and (spot or not high-volume)", []string{"us"}, true},
+	}
+	for _, tt := range tests {
+		expr, err := parseTagExpr(tt.expr)
+		if err != nil {
+			t.Errorf("parseTagExpr(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := expr(tt.tags); got != tt.want {
+			t.Errorf("parseTagExpr(%q)(%q) = %v, want %v", tt.expr, tt.tags, got, tt.want)
+		}
+	}
+
+	malformed := []struct {
+		expr, err string
+	}{
+		{"", "unexpected end of expression"},
+		{"a AND", "unexpected end of expression"},
+		{"NOT", "unexpected end of expression"},
+		{"(a OR b", "missing )"},
+		{"a)", `unexpected ")"`},
+		{"()", `unexpected ")"`},
+		{"a b", `unexpected "b"`},
+		{"OR a", `unexpected "OR"`},
+		{"a & b", `unexpected "&"`},
+		{"a || && b", `unexpected "&&"`},
+	}
+	for _, tt := range malformed {
+		_, err := parseTagExpr(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseTagExpr(%q) error = %v, want %q", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	write := func(t *testing.T, dir, name, body string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("override", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "shared/base.json", `{
+			"proxy": "http://base:3128",
+			"noProxy": "localhost",
+			"exchanges": [
+				{"name": "bybit", "script": "bybit.py", "format": "remove_dash"},
+				{"name": "kraken", "enabled": false}
+			]
+		}`)
+		path := write(t, dir, "prod.json", `{
+			"extends": "shared/base.json",
+			"proxy": "http://prod:3128",
+			"exchanges": [
+				{"name": "bybit", "format": "keep_original"},
+				{"name": "okx", "script": "okx.py"}
+			]
+		}`)
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Extends != "" || cfg.Proxy != "http://prod:3128" || cfg.NoProxy != "localhost" {
+			t.Errorf("extends, proxy, noProxy = %q, %q, %q; want \"\", the overlay's proxy and the base's noProxy", cfg.Extends, cfg.Proxy, cfg.NoProxy)
+		}
+		var names []string
+		for _, ex := range cfg.Exchanges {
+			names = append(names, ex.Name)
+		}
+		if want := []string{"bybit", "kraken", "okx"}; !slices.Equal(names, want) {
+			t.Fatalf("exchanges = %q, want %q", names, want)
+		}
+		bybit, kraken := cfg.Exchanges[0], cfg.Exchanges[1]
+		if bybit.Script == nil || *bybit.Script != "bybit.py" || bybit.Format == nil || *bybit.Format != "keep_original" {
+			t.Errorf("bybit = script %v, format %v; want the base's script and the overlay's format", bybit.Script, bybit.Format)
+		}
+		if kraken.Enabled == nil || *kraken.Enabled {
+			t.Errorf("kraken enabled = %v, want the base's false", kraken.Enabled)
+		}
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		dir := t.TempDir()
+		path := write(t, dir, "prod.json", `{"extends": "base.json", "exchanges": []}`)
+		_, err := loadConfig(path)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("loadConfig = %v, want a not-exist error", err)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		dir := t.TempDir()
+		a := write(t, dir, "a.json", `{"extends": "b.json", "exchanges": []}`)
+		b := write(t, dir, "b.json", `{"extends": "a.json", "exchanges": []}`)
+		_, err := loadConfig(a)
+		want := fmt.Sprintf("config: circular extends: %s -> %s -> %s", a, b, a)
+		if err == nil || err.Error() != want {
+			t.Errorf("loadConfig = %v, want %q", err, want)
+		}
+	})
+}
+
+func TestNormalizeRulesApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  normalizeRules
+		symbol string
+		want   string
+	}{
+		{"replace", normalizeRules{Replace: []normalizeReplace{{Pattern: `^(\w+)-PERP$`, With: "${1}-USD"}}}, "BTC-PERP", "BTC-USD"},
+		{"replace in order", normalizeRules{Replace: []normalizeReplace{{Pattern: `^XBT`, With: "BTC"}, {Pattern: `BTC`, With: "btc"}}}, "XBTUSD", "btcUSD"},
+		{"uppercase", normalizeRules{Uppercase: true}, "btc_usdt", "BTC_USDT"},
+		{"quote after separator", normalizeRules{QuoteAliases: map[string]string{"USD": "USDT"}}, "BTC-USD", "BTC-USDT"},
+		{"quote after separator matched whole", normalizeRules{QuoteAliases: map[string]string{"USD": "USDT"}}, "BTC/BUSD", "BTC/BUSD"},
+		{"quote suffix, longest first", normalizeRules{QuoteAliases: map[string]string{"USD": "USDT", "BUSD": "USDT"}}, "ETHBUSD", "ETHUSDT"},
+		{"quote suffix, not the whole symbol", normalizeRules{QuoteAliases: map[string]string{"USD": "USDT"}}, "USD", "USD"},
+		{"strip", normalizeRules{Strip: []string{"_", "/"}}, "BTC_USDT/PERP", "BTCUSDTPERP"},
+		{"steps in field order", normalizeRules{Uppercase: true, QuoteAliases: map[string]string{"USD": "USDT"}, Strip: []string{"-"}}, "btc-usd", "BTCUSDT"},
+	}
+	for _, tt := range tests {
+		if err := tt.rules.compile(); err != nil {
+			t.Fatalf("%s: compile: %v", tt.name, err)
+		}
+		if got := tt.rules.apply(tt.symbol); got != tt.want {
+			t.Errorf("%s: apply(%q) = %q, want %q", tt.name, tt.symbol, got, tt.want)
+		}
+	}
+
+	for _, rules := range []normalizeRules{
+		{Replace: []normalizeReplace{{Pattern: "(", With: ""}}},
+		{Strip: []string{"-", ""}},
+	} {
+		if err := rules.compile(); err == nil {
+			t.Errorf("compile(%+v) succeeded, want an error", rules)
+		}
+	}
+}
+
+func TestComputeDurationStats(t *testing.T) {
+	results := func(seconds ...int) []ScriptResult {
+		rs := make([]ScriptResult, len(seconds))
+		for i, s := range seconds {
+			rs[i].Duration = time.Duration(s) * time.Second
+		}
+		return rs
+	}
+	hundred := make([]int, 100)
+	for i := range hundred {
+		hundred[i] = 100 - i
+	}
+	tests := []struct {
+		name    string
+		results []ScriptResult
+		want    *durationStats // in seconds
+	}{
+		{"empty", nil, nil},
+		{"one", results(3), &durationStats{Count: 1, Total: 3, Min: 3, Max: 3, P50: 3, P90: 3, P99: 3}},
+		{"two", results(4, 2), &durationStats{Count: 2, Total: 6, Min: 2, Max: 4, P50: 2, P90: 4, P99: 4}},
+		{"ten", results(7, 3, 10, 1, 9, 2, 8, 4, 6, 5), &durationStats{Count: 10, Total: 55, Min: 1, Max: 10, P50: 5, P90: 9, P99: 10}},
+		{"hundred", results(hundred...), &durationStats{Count: 100, Total: 5050, Min: 1, Max: 100, P50: 50, P90: 90, P99: 99}},
+		{"skipped left out", append(results(4, 2), ScriptResult{Skipped: true}), &durationStats{Count: 2, Total: 6, Min: 2, Max: 4, P50: 2, P90: 4, P99: 4}},
+		{"only skipped", []ScriptResult{{Skipped: true}}, nil},
+	}
+	for _, tt := range tests {
+		if tt.want != nil {
+			for _, d := range []*time.Duration{&tt.want.Total, &tt.want.Min, &tt.want.Max, &tt.want.P50, &tt.want.P90, &tt.want.P99} {
+				*d *= time.Second
+			}
+		}
+		got := computeDurationStats(tt.results)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: computeDurationStats = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryIgnoresDependencySkips(t *testing.T) {
+	dir := t.TempDir()
+	jobs := []Exchange{
+		{Name: "binance", Script: writeStub(t, dir, "binance", "exit 1\n")},
+		{Name: "binance_futures", Script: writeStub(t, dir, "binance_futures", "exit 0\n"), DependsOn: []string{"binance"}},
+	}
+	runner := &Runner{
+		ScriptDir: dir,
+		Jobs:      jobs,
+		Options:   runOptions{Interpreter: "/bin/sh"},
+		Retry:     &retryPolicy{},
+		Parallel:  1,
+	}
+	hist := &history{}
+	for range 3 {
+		results := runner.Run(context.Background())
+		if i := slices.IndexFunc(results, func(r ScriptResult) bool { return r.Name == "binance_futures" }); i < 0 || !results[i].Skipped {
+			t.Fatalf("binance_futures was not skipped for its failed dependency: %+v", results)
+		}
+		hist.Runs = append(hist.Runs, newHistoryRun(time.Now(), 0, results))
+	}
+
+	plan := []planEntry{{Exchange: jobs[0], Run: true}, {Exchange: jobs[1], Run: true}}
+	quarantined := quarantine(plan, hist, 3, false)
+	if want := map[string]int{"binance": 3}; !maps.Equal(quarantined, want) {
+		t.Errorf("quarantine = %v, want %v", quarantined, want)
+	}
+	if plan[0].Run || !plan[1].Run {
+		t.Errorf("plan runs binance=%v binance_futures=%v, want only the dependent to run", plan[0].Run, plan[1].Run)
+	}
+	if failing := hist.Runs[0].failing(); !slices.Equal(failing, []string{"binance"}) {
+		t.Errorf("failing = %q, want only the exchange that ran and failed", failing)
+	}
+}
+
+func TestRequiredExchangeSkippedForDependency(t *testing.T) {
+	dir := t.TempDir()
+	writeStub(t, dir, "binance", "exit 1\n")
+	writeStub(t, dir, "binance_futures", "exit 0\n")
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"exchanges": [
+		{"name": "binance", "script": "binance.sh", "enabled": true},
+		{"name": "binance_futures", "script": "binance_futures.sh", "enabled": true, "dependsOn": ["binance"]}
+	]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		require string
+		code    int
+	}{
+		{"binance_futures", 0}, // skipped, so it never failed
+		{"binance", 1},         // -fail-threshold-percent 100 alone would pass
+	}
+	for _, tt := range tests {
+		code, stderr := runMain(t, "-config", config, "-interpreter", "/bin/sh", "-history", "", "-fail-threshold-percent", "100", "-require", tt.require, dir)
+		if code != tt.code {
+			t.Errorf("-require %s: exit code %d, want %d; stderr:\n%s", tt.require, code, tt.code, stderr)
+		}
+	}
+}
+
+// BenchmarkOutputBuffer writes console-sized lines to a file the way
+// chatty scripts reach the console, one write per line, with and without
+// -output-buffer.
+func BenchmarkOutputBuffer(b *testing.B) {
+	line := []byte("🔄 [12/17 - 70.6%] bybit: BTCUSDT ETHUSDT SOLUSDT XRPUSDT DOGEUSDT\n")
+	for _, size := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			f, err := os.Create(filepath.Join(b.TempDir(), "console.log"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			var w io.Writer = f
+			if size > 0 {
+				buffered := &bufferedOutput{w: bufio.NewWriterSize(f, size)}
+				defer buffered.w.Flush()
+				w = buffered
+			}
+			b.SetBytes(int64(len(line)))
+			for b.Loop() {
+				w.Write(line)
+			}
+		})
+	}
+}
+
+func TestBuildPlanOrder(t *testing.T) {
+	roster := []Exchange{
+		{Name: "bitmart", Enabled: true},
+		{Name: "bybit", Enabled: true},
+		{Name: "gateio", Enabled: true, Priority: 5},
+		{Name: "kraken", Enabled: true},
+	}
+	tests := []struct {
+		name string
+		opts planOptions
+		want []string
+	}{
+		{"roster order at equal priority", planOptions{}, []string{"gateio", "bitmart", "bybit", "kraken"}},
+		{"config order at equal priority", planOptions{ConfigOrder: []string{"kraken", "bybit"}}, []string{"gateio", "kraken", "bybit", "bitmart"}},
+		{"priority before config order", planOptions{ConfigOrder: []string{"kraken", "gateio"}}, []string{"gateio", "kraken", "bitmart", "bybit"}},
+		{"-from-file order", planOptions{Listed: []string{"kraken", "bitmart"}, ConfigOrder: []string{"bybit"}}, []string{"kraken", "bitmart", "bybit", "gateio"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, entry := range buildPlan(t.TempDir(), roster, tt.opts) {
+			got = append(got, entry.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: plan order %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeUsesExitCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int
+		exitCodes map[int]string
+		want      bool
+	}{
+		{"zero", 0, nil, true},
+		{"unmapped", 3, nil, false},
+		{"zero mapped to failure", 0, map[int]string{0: "failure"}, false},
+		{"mapped to success", 3, map[int]string{3: "success"}, true},
+		{"mapped to skip", 4, map[int]string{4: "skip"}, true},
+		{"mapped to retry", 75, map[int]string{75: "retry"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ex := Exchange{Name: "bybit", ExitCodes: tt.exitCodes}
+			ex.Script = writeStub(t, dir, ex.Name, fmt.Sprintf("mkdir -p data_bybit_1d\necho BTCUSDT > data_bybit_1d/BTCUSDT_1d.csv\nexit %d\n", tt.code))
+			opts := runOptions{Interpreter: "/bin/sh", OutputFormat: "csv"}
+			var out strings.Builder
+			console = &out
+			defer func() { console = io.Discard }()
+			probed := probeExchange(context.Background(), dir, ex, opts)
+			if probed != tt.want {
+				t.Errorf("probe passed = %v, want %v", probed, tt.want)
+			}
+			mark := "✗"
+			if tt.want {
+				mark = "✓"
+			}
+			if !strings.Contains(out.String(), "\n"+mark+" exit status") {
+				t.Errorf("exit status check not marked %s:\n%s", mark, out.String())
+			}
+			if result := runScript(context.Background(), dir, ex, opts, 1, 1); result.Success != probed {
+				t.Errorf("probe passed = %v but a real run succeeded = %v", probed, result.Success)
+			}
+		})
+	}
+}